@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"log"
+)
+
+type (
+	// aggregateResult 同时对多个服务器测速的合计结果
+	aggregateResult struct {
+		Servers  speedtestclient.SpeedtestServerList `json:"servers"`
+		Download *speedtestclient.UpDownloadRes      `json:"download,omitempty"`
+		Upload   *speedtestclient.UpDownloadRes      `json:"upload,omitempty"`
+		Error    string                              `json:"error,omitempty"`
+		// Violations 不满足的阈值
+		Violations []*violation `json:"violations,omitempty"`
+	}
+)
+
+// aggregateParallel 并行数至少为服务器的数量, 使每个服务器至少一个连接,
+// 没有设置的并行数自动提高, 设置的并行数过小时返回错误
+func aggregateParallel(n int) error {
+	for _, p := range []struct {
+		name     string
+		parallel *int
+	}{
+		{"down_parallel", &downloadParallel},
+		{"up_parallel", &uploadParallel},
+	} {
+		if *p.parallel >= n {
+			continue
+		}
+		if configSources[p.name] != "default" {
+			return usageErrorf("aggregate requires %s of at least the number of servers (%d)", p.name, n)
+		}
+		log.Printf("%s is raised to %d, one stream per server\n", p.name, n)
+		*p.parallel = n
+	}
+	return nil
+}
+
+// runAggregate 同时对 servers 下载和上传, 合计速度, 用于测量单个服务器无法跑满的链路
+func runAggregate(servers speedtestclient.SpeedtestServerList) (res *aggregateResult, err error) {
+	res = &aggregateResult{
+		Servers: servers,
+	}
+	defer func() {
+		if err != nil {
+			res.Error = err.Error()
+		}
+	}()
+	if isHTTPMode {
+		return res, fmt.Errorf("aggregate is not supported in http mode")
+	}
+
+	topt := &testOptions{
+		localAddr: localAddr,
+	}
+	mh := make(speedtestclient.MultiHost, 0, len(servers))
+	for _, server := range servers {
+		_, withHost, err := newTester(server, topt)
+		if err != nil {
+			return res, err
+		}
+		mh = append(mh, withHost)
+	}
+
+	if !disableDownload {
+		opt := newUpDownloadOption(topt)
+		opt.Timeout = downloadDuration
+		opt.Parallel = downloadParallel
+		res.Download, err = mh.Download(&opt, upDownCallback("↓"))
+		if err != nil {
+			return res, fmt.Errorf("DOWNLOAD error: %s", err)
+		}
+		printAggregateRes("DOWNLOAD", res.Download)
+	}
+
+	if !disableUpload {
+		opt := newUpDownloadOption(topt)
+		opt.Timeout = uploadDuration
+		opt.Parallel = uploadParallel
+		res.Upload, err = mh.Upload(&opt, upDownCallback("↑"))
+		if err != nil {
+			return res, fmt.Errorf("UPLOAD error: %s", err)
+		}
+		printAggregateRes("UPLOAD", res.Upload)
+	}
+	return res, nil
+}
+
+// printAggregateRes 输出合计结果和各服务器的结果
+func printAggregateRes(op string, res *speedtestclient.UpDownloadRes) {
+	printRes(op, res)
+	for _, hostRes := range res.Hosts {
+		prefix := "[" + hostRes.Host + "] "
+		printRes(prefix+op, hostRes)
+		for _, warning := range hostRes.DownloadSample.Warnings() {
+			log.Printf("%sWARNING: %s\n", prefix, warning)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// spreadThreshold 各服务器的速度相差超过该倍数时, 认为瓶颈不在本地线路
+	spreadThreshold = 2
+	// defaultServerPort 没有指定端口时, 测速服务器的端口
+	defaultServerPort = "8080"
+)
+
+// parseServerEntries 解析服务器 id 或者 host[:port] 的列表, 没有端口时使用 defaultServerPort
+func parseServerEntries(entries []string) (ids []int, hosts []string) {
+	for _, entry := range entries {
+		if id, err := strconv.Atoi(entry); err == nil {
+			ids = append(ids, id)
+			continue
+		}
+		hosts = append(hosts, serverHost(entry))
+	}
+	return
+}
+
+// serverHost 没有端口时加上 defaultServerPort
+func serverHost(host string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), defaultServerPort)
+	}
+	return host
+}
+
+// readServersFile 读取服务器列表文件, 每行一个服务器 id 或者 host[:port], # 之后为注释
+func readServersFile(name string) (entries []string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// selectServers 根据 server_id, server_host 和 servers_file 返回要测速的服务器, 都没有设置则返回 nil
+// 同时设置 server_id 和 server_host 时, 与之前一样 server_host 优先
+func selectServers() (servers speedtestclient.SpeedtestServerList, err error) {
+	var entries []string
+	if speedtestServerHost != "" {
+		if speedtestServerID != "" {
+			log.Println("server_id is ignored, server_host takes priority")
+		}
+		entries = splitList(speedtestServerHost)
+	} else {
+		entries = splitList(speedtestServerID)
+	}
+	if serversFile != "" {
+		fileEntries, err := readServersFile(serversFile)
+		if err != nil {
+			return nil, usageErrorf("read servers_file error: %s", err)
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	return lookupServers(parseServerEntries(entries))
+}
+
+// lookupServers 在所有服务器中查找 ids, 再加上 hosts, 找不到时返回退出码为 exitNoServer 的错误
+func lookupServers(ids []int, hosts []string) (servers speedtestclient.SpeedtestServerList, err error) {
+	if len(ids) > 0 {
+		servList, err := client.GetAllServerList()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			server := servList.FindByID(id)
+			if server == nil {
+				return nil, noServerErrorf("server host not found, id: %d", id)
+			}
+			servers = append(servers, server)
+		}
+	}
+	for _, host := range hosts {
+		servers = append(servers, &speedtestclient.SpeedtestServer{
+			Host: host,
+		})
+	}
+	return servers, nil
+}
+
+// checkMultiServerFlags 测速多个服务器时, 检查不能同时使用的参数
+func checkMultiServerFlags() error {
+	var conflicts []string
+	for name, set := range map[string]bool{
+		"failover":       failover > 0,
+		"all_interfaces": isAllInterfaces,
+		"dual_stack":     isDualStack,
+		"compare_cc":     compareCC != "",
+		"compare_dscp":   compareDSCP != "",
+		"compare_mptcp":  isCompareMPTCP,
+	} {
+		if set {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return usageErrorf("%s not supported when testing several servers", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// serverLabel 服务器在比较中的名称
+func serverLabel(server *speedtestclient.SpeedtestServer) string {
+	if server.ID == 0 {
+		return server.Host
+	}
+	return strconv.Itoa(server.ID) + " " + server.Sponsor
+}
+
+// runCampaign 对每个服务器执行完整的测速, interleave 为 true 时,
+// 每个阶段依次对所有服务器执行, 使各服务器的同一阶段处于相近的时间
+func runCampaign(servers speedtestclient.SpeedtestServerList, interleave bool) (report *comparisonReport) {
+	report = &comparisonReport{
+		Mode:    "servers",
+		Results: make([]*testResult, len(servers)),
+	}
+	runs := make([]*testRun, len(servers))
+	for i, server := range servers {
+		runs[i] = newTestRun(server, &testOptions{
+			label:     serverLabel(server),
+			localAddr: localAddr,
+		})
+	}
+
+	finish := func(i int) {
+		res, err := runs[i].finish()
+		if err != nil {
+			log.Println(err)
+			res.Error = err.Error()
+		}
+		report.Results[i] = res
+	}
+	if interleave {
+		for phase := range testPhases {
+			for _, run := range runs {
+				run.step(phase)
+			}
+		}
+		for i := range runs {
+			finish(i)
+		}
+	} else {
+		for i, run := range runs {
+			for phase := range testPhases {
+				if !run.step(phase) {
+					break
+				}
+			}
+			finish(i)
+		}
+	}
+
+	report.diffFirst()
+	report.Summary = campaignSummary(report.Results)
+	return
+}
+
+// campaignSummary 根据各服务器速度的差异, 判断瓶颈在本地线路还是在中转
+func campaignSummary(results []*testResult) string {
+	var min, max int64
+	var minRes, maxRes *testResult
+	for _, res := range results {
+		if res.Error != "" || res.Download == nil {
+			continue
+		}
+		speed := res.Download.AverageSpeed
+		if minRes == nil || speed < min {
+			min, minRes = speed, res
+		}
+		if maxRes == nil || speed > max {
+			max, maxRes = speed, res
+		}
+	}
+	if minRes == nil || minRes == maxRes {
+		return ""
+	}
+	if min == 0 || max/min >= spreadThreshold {
+		return fmt.Sprintf("download differs by server (%s at %s vs %s at %s), the bottleneck is likely in transit or at the slower servers", maxRes.Label, formatSpeed(max), minRes.Label, formatSpeed(min))
+	}
+	return fmt.Sprintf("download is similar across servers (%s - %s), the bottleneck is likely the local link", formatSpeed(min), formatSpeed(max))
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 退出码
+const (
+	exitOK        = 0 // 成功
+	exitError     = 1 // 测速或网络错误
+	exitUsage     = 2 // 参数或配置错误
+	exitThreshold = 3 // 测速结果不满足阈值
+	exitNoServer  = 4 // 找不到服务器
+)
+
+type (
+	// command 子命令
+	command struct {
+		name  string // 名称, 如 run, servers list
+		args  string // 位置参数的说明
+		short string // 简要说明
+		flags func(fs *flag.FlagSet)
+		run   func(args []string) error
+	}
+
+	// codeError 指定退出码的错误, 其他错误的退出码为 exitError
+	codeError struct {
+		code int
+		err  error
+	}
+)
+
+var (
+	// commands 各个子命令, 不使用子命令时为 legacyCommand
+	commands = []*command{
+		{
+			name:  "run",
+			short: "run DNS, HI, PING, DOWNLOAD and UPLOAD against a server (default)",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addConnFlags(fs)
+				addServerFlags(fs)
+				addPingFlags(fs)
+				addTestFlags(fs)
+				addSpeedThresholdFlags(fs)
+				addLatencyThresholdFlags(fs)
+			},
+			run: cmdRun,
+		},
+		{
+			name:  "servers list",
+			short: "list nearby servers, or all servers with -all",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addConnFlags(fs)
+				fs.BoolVar(&isListAll, "all", false, "list all Speedtest.net servers instead of nearby ones")
+			},
+			run: cmdServersList,
+		},
+		{
+			name:  "servers search",
+			args:  "<text>",
+			short: "search all servers by id, name, sponsor or host",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addConnFlags(fs)
+			},
+			run: cmdServersSearch,
+		},
+		{
+			name:  "servers show",
+			args:  "<id or host[:port]>...",
+			short: "show servers and their software version",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addConnFlags(fs)
+			},
+			run: cmdServersShow,
+		},
+		{
+			name:  "ping",
+			short: "HI and PING a server",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addConnFlags(fs)
+				addServerFlags(fs)
+				addPingFlags(fs)
+				addLatencyThresholdFlags(fs)
+			},
+			run: cmdPing,
+		},
+		{
+			name:  "local-info",
+			short: "show local info, e.g. ISP",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addConnFlags(fs)
+			},
+			run: cmdLocalInfo,
+		},
+		{
+			name:  "serve",
+			short: "run a speedtest server for HI, PING, DOWNLOAD and UPLOAD",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				fs.StringVar(&listenAddr, "listen", ":8080", "address to listen on")
+			},
+			run: cmdServe,
+		},
+		{
+			name:  "history",
+			short: "show results recorded with -history_file",
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addHistoryFlag(fs)
+				fs.IntVar(&historyCount, "n", 20, "show the last n results, 0 for all")
+			},
+			run: cmdHistory,
+		},
+	}
+
+	// legacyCommand 不使用子命令时, 接受 run 的参数和旧的 list_all 等参数
+	legacyCommand = &command{
+		flags: func(fs *flag.FlagSet) {
+			addConfigFlags(fs)
+			addConnFlags(fs)
+			addServerFlags(fs)
+			addPingFlags(fs)
+			addTestFlags(fs)
+			addSpeedThresholdFlags(fs)
+			addLatencyThresholdFlags(fs)
+			addLegacyFlags(fs)
+		},
+		run: cmdLegacy,
+	}
+)
+
+func (e codeError) Error() string {
+	return e.err.Error()
+}
+
+// usageError 参数或配置错误
+func usageError(err error) error {
+	return codeError{exitUsage, err}
+}
+
+// usageErrorf 返回参数或配置错误
+func usageErrorf(format string, a ...interface{}) error {
+	return usageError(fmt.Errorf(format, a...))
+}
+
+// noServerErrorf 返回找不到服务器的错误
+func noServerErrorf(format string, a ...interface{}) error {
+	return codeError{exitNoServer, fmt.Errorf(format, a...)}
+}
+
+// progName 程序名
+func progName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// lookupCommand 查找子命令, 返回子命令和其余的参数, 找不到则返回 nil
+func lookupCommand(args []string) (cmd *command, rest []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return legacyCommand, args
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):]
+		}
+	}
+	return nil, args
+}
+
+// printUsage 输出子命令的列表
+func printUsage() {
+	w := os.Stderr
+	fmt.Fprintf(w, "Usage: %s <command> [options] [arguments]\n\nCommands:\n", progName())
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "  %-16s %s\n", "help", "show the options of a command")
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the options of a command.\n", progName())
+	fmt.Fprintf(w, "Without a command, the options of run and the legacy options below are accepted.\n")
+	fmt.Fprintf(w, "Exit codes: %d success, %d test or network error, %d invalid usage or config, %d below threshold, %d no server found.\n", exitOK, exitError, exitUsage, exitThreshold, exitNoServer)
+}
+
+// newFlagSet 创建子命令的参数
+func (cmd *command) newFlagSet() *flag.FlagSet {
+	name := progName()
+	if cmd.name != "" {
+		name += " " + cmd.name
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cmd.flags(fs)
+	fs.Usage = func() {
+		if cmd == legacyCommand {
+			printUsage()
+			fmt.Fprintf(fs.Output(), "\nUsage of %s:\n", fs.Name())
+		} else {
+			fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n\nOptions:\n", strings.TrimSpace(fs.Name()+" [options] "+cmd.args), cmd.short)
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runCommand 解析参数并执行子命令, 返回退出码
+func runCommand(args []string) int {
+	if len(args) > 0 && args[0] == "help" {
+		cmd, _ := lookupCommand(args[1:])
+		if cmd == nil || len(args) == 1 {
+			printUsage()
+			return exitOK
+		}
+		cmd.newFlagSet().Usage()
+		return exitOK
+	}
+
+	cmd, rest := lookupCommand(args)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", strings.Join(args, " "))
+		printUsage()
+		return exitUsage
+	}
+
+	fs := cmd.newFlagSet()
+	err := fs.Parse(rest)
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	err = applyConfig(fs)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	if isPrintConfig {
+		if isJSON {
+			printJSON(effectiveConfig(fs))
+		} else {
+			printConfig(os.Stdout, fs)
+		}
+		return exitOK
+	}
+
+	err = setupClient()
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	err = cmd.run(fs.Args())
+	if err != nil {
+		log.Println(err)
+		if ce, ok := err.(codeError); ok {
+			return ce.code
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+// printServers 输出服务器列表
+func printServers(title string, servList speedtestclient.SpeedtestServerList) {
+	if isJSON {
+		printJSON(servList)
+		return
+	}
+	fmt.Println(title)
+	servList.PrintTo(os.Stdout)
+}
+
+func cmdServersList(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	if isListAll {
+		servList, err := client.GetAllServerList()
+		if err != nil {
+			return err
+		}
+		printServers("All Server List: ", servList)
+		return nil
+	}
+
+	_, servList, err := client.GetLocalInfoAndServerList()
+	if err != nil {
+		return err
+	}
+	printServers("Nearby Server List: ", servList)
+	return nil
+}
+
+func cmdServersSearch(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("servers search requires the text to search")
+	}
+	text := strings.ToLower(strings.Join(args, " "))
+	servList, err := client.GetAllServerList()
+	if err != nil {
+		return err
+	}
+
+	var found speedtestclient.SpeedtestServerList
+	for _, server := range servList {
+		if strconv.Itoa(server.ID) == text ||
+			strings.Contains(strings.ToLower(server.Name), text) ||
+			strings.Contains(strings.ToLower(server.Sponsor), text) ||
+			strings.Contains(strings.ToLower(server.Host), text) {
+			found = append(found, server)
+		}
+	}
+	if len(found) == 0 {
+		return noServerErrorf("server not found: %s", text)
+	}
+	printServers("Search Result: ", found)
+	return nil
+}
+
+func cmdServersShow(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("servers show requires server ids or hosts")
+	}
+	ids, hosts := parseServerEntries(args)
+
+	var servList speedtestclient.SpeedtestServerList
+	if len(ids) > 0 {
+		allList, err := client.GetAllServerList()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			server := allList.FindByID(id)
+			if server == nil {
+				return noServerErrorf("server host not found, id: %d", id)
+			}
+			servList = append(servList, server)
+		}
+	}
+	for _, host := range hosts {
+		servList = append(servList, &speedtestclient.SpeedtestServer{
+			Host: host,
+		})
+	}
+
+	inventory := client.Inventory(servList)
+	if isJSON {
+		printJSON(inventory)
+		return nil
+	}
+	fmt.Println("Server List: ")
+	servList.PrintTo(os.Stdout)
+	fmt.Println("Server Inventory: ")
+	inventory.PrintTo(os.Stdout)
+	return nil
+}
+
+func cmdPing(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	if isJSON {
+		out = os.Stderr
+	}
+	err := parseThresholds()
+	if err != nil {
+		return err
+	}
+	servers, err := selectServers()
+	if err != nil {
+		return err
+	}
+	server, _, err := selectServer(servers)
+	if err != nil {
+		return err
+	}
+
+	run := newTestRun(server, &testOptions{
+		localAddr: localAddr,
+	})
+	for _, phase := range []func(run *testRun) error{(*testRun).dns, (*testRun).hi, (*testRun).ping} {
+		if run.err == nil {
+			run.err = phase(run)
+		}
+	}
+	res, err := run.finish()
+	if err == nil {
+		err = checkResults(res)
+	}
+	if isJSON {
+		printJSON(res)
+	}
+	return err
+}
+
+func cmdLocalInfo(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	li, _, err := client.GetLocalInfoAndServerList()
+	if err != nil {
+		return err
+	}
+	if isJSON {
+		printJSON(li)
+		return nil
+	}
+	fmt.Println("Local Info: ")
+	li.PrintTo(os.Stdout)
+	return nil
+}
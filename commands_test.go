@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupCommand(t *testing.T) {
+	cases := []struct {
+		args []string
+		cmd  string // nil 为 "-", 旧的参数为 "legacy"
+		rest []string
+	}{
+		{nil, "legacy", nil},
+		{[]string{"-list_all"}, "legacy", []string{"-list_all"}},
+		{[]string{"-list_nearby", "-json"}, "legacy", []string{"-list_nearby", "-json"}},
+		{[]string{"-inventory", "-server_host", "a.example"}, "legacy", []string{"-inventory", "-server_host", "a.example"}},
+		{[]string{"-server_id", "1234"}, "legacy", []string{"-server_id", "1234"}},
+		{[]string{"run", "-server_id", "1234"}, "run", []string{"-server_id", "1234"}},
+		{[]string{"servers", "list", "-all"}, "servers list", []string{"-all"}},
+		{[]string{"servers", "show", "1234"}, "servers show", []string{"1234"}},
+		{[]string{"local-info"}, "local-info", []string{}},
+		{[]string{"servers"}, "-", []string{"servers"}},
+		{[]string{"list_all"}, "-", []string{"list_all"}},
+	}
+	for _, c := range cases {
+		cmd, rest := lookupCommand(c.args)
+		name := "-"
+		switch {
+		case cmd == legacyCommand:
+			name = "legacy"
+		case cmd != nil:
+			name = cmd.name
+		}
+		if name != c.cmd || strings.Join(rest, " ") != strings.Join(c.rest, " ") {
+			t.Errorf("lookupCommand(%q) = %s %q, want %s %q", c.args, name, rest, c.cmd, c.rest)
+		}
+	}
+}
+
+func TestLegacyFlags(t *testing.T) {
+	// 旧的参数只在不使用子命令时可用
+	for _, name := range []string{"list_all", "list_nearby", "local_info", "inventory"} {
+		if legacyCommand.newFlagSet().Lookup(name) == nil {
+			t.Errorf("legacy flag -%s not found", name)
+		}
+		cmd, _ := lookupCommand([]string{"run"})
+		if cmd.newFlagSet().Lookup(name) != nil {
+			t.Errorf("-%s should not be accepted by run", name)
+		}
+	}
+
+	if code := runCLI(t, "run", "-list_all"); code != exitUsage {
+		t.Errorf("run -list_all exit code = %d, want %d", code, exitUsage)
+	}
+	// -inventory 不需要访问 Speedtest.net, 对本地的 serve 执行
+	if code := runCLI(t, "-inventory", "-server_host", startServe(t)); code != exitOK {
+		t.Errorf("-inventory exit code = %d, want %d", code, exitOK)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"github.com/iikira/iikira-go-utils/utils/converter"
+	"github.com/iikira/speedtest/speedtestclient"
+	"github.com/olekukonko/tablewriter"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// comparisonReport 以不同设置对同一服务器测速的比较结果
+	comparisonReport struct {
+		Mode       string        `json:"mode"` // uplinks, dual_stack, congestion, dscp, mptcp, servers 等
+		Concurrent bool          `json:"concurrent"`
+		Results    []*testResult `json:"results"`
+		// 并发测速时, 各项平均速度之和
+		AggregateDownload int64 `json:"aggregate_download,omitempty"`
+		AggregateUpload   int64 `json:"aggregate_upload,omitempty"`
+		// 比较双栈, 拥塞控制, DSCP, MPTCP 或服务器时, 其余结果相对于第一个结果的差异
+		Diffs []*resultDiff `json:"diffs,omitempty"`
+		// 比较服务器时, 对瓶颈位置的判断
+		Summary string `json:"summary,omitempty"`
+	}
+
+	// resultDiff 两次测速结果的差异, b 相对于 a
+	resultDiff struct {
+		A              string        `json:"a"`
+		B              string        `json:"b"`
+		Latency        time.Duration `json:"latency"`         // 延时之差, PING 平均值, 没有则用 HI
+		DownloadChange float64       `json:"download_change"` // 下载速度的变化比例
+		UploadChange   float64       `json:"upload_change"`   // 上传速度的变化比例
+	}
+)
+
+// runComparison 依次(或并发)以 topts 中的设置对 server 测速.
+// 并发时各阶段之间同步, 所有测速的同一阶段同时开始, 使各项的 DOWNLOAD 和 UPLOAD 互相重叠,
+// 速度之和才是合计的容量
+func runComparison(mode string, server *speedtestclient.SpeedtestServer, topts []*testOptions, concurrent bool) (report *comparisonReport) {
+	report = &comparisonReport{
+		Mode:       mode,
+		Concurrent: concurrent,
+		Results:    make([]*testResult, len(topts)),
+	}
+	runs := make([]*testRun, len(topts))
+	for i, topt := range topts {
+		runs[i] = newTestRun(server, topt)
+	}
+
+	finish := func(i int) {
+		res, err := runs[i].finish()
+		if err != nil {
+			log.Println(err)
+			res.Error = err.Error()
+		}
+		report.Results[i] = res
+	}
+
+	if !concurrent {
+		for i, run := range runs {
+			for phase := range testPhases {
+				if !run.step(phase) {
+					break
+				}
+			}
+			finish(i)
+		}
+		return
+	}
+
+	for phase := range testPhases {
+		wg := sync.WaitGroup{}
+		for _, run := range runs {
+			wg.Add(1)
+			go func(run *testRun) {
+				defer wg.Done()
+				run.step(phase)
+			}(run)
+		}
+		wg.Wait()
+	}
+	for i := range runs {
+		finish(i)
+	}
+
+	for _, res := range report.Results {
+		if res.Download != nil {
+			report.AggregateDownload += res.Download.AverageSpeed
+		}
+		if res.Upload != nil {
+			report.AggregateUpload += res.Upload.AverageSpeed
+		}
+	}
+	return
+}
+
+func (report *comparisonReport) PrintTo(w io.Writer) {
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetColumnSeparator("")
+	table.SetHeader([]string{"TEST", "LOCAL ADDR", "REMOTE ADDR", "HI", "PING AVG", "DOWNLOAD", "UPLOAD", "ERROR"})
+	for _, res := range report.Results {
+		table.Append(res.row())
+	}
+	if report.Concurrent {
+		table.Append([]string{"TOTAL", "", "", "", "", formatSpeed(report.AggregateDownload), formatSpeed(report.AggregateUpload), ""})
+	}
+	table.Render()
+	return
+}
+
+// diffFirst 计算其余结果相对于第一个结果的差异, 跳过出错的结果
+func (report *comparisonReport) diffFirst() {
+	first := report.Results[0]
+	if first.Error != "" {
+		return
+	}
+	for _, res := range report.Results[1:] {
+		if res.Error == "" {
+			report.Diffs = append(report.Diffs, diffResults(first, res))
+		}
+	}
+}
+
+// row 用于比较的表格行
+func (res *testResult) row() []string {
+	row := []string{res.Label, res.LocalAddr, res.RemoteAddr, "", "", "", "", res.Error}
+	if res.Hi != nil {
+		row[3] = res.Hi.Latency.String()
+	}
+	if res.Ping != nil {
+		row[4] = res.Ping.Average.String()
+	}
+	if res.Download != nil {
+		row[5] = formatSpeed(res.Download.AverageSpeed)
+	}
+	if res.Upload != nil {
+		row[6] = formatSpeed(res.Upload.AverageSpeed)
+	}
+	return row
+}
+
+// latency PING 平均延时, 没有则用 HI 的延时
+func (res *testResult) latency() time.Duration {
+	if res.Ping != nil {
+		return res.Ping.Average
+	}
+	if res.Hi != nil {
+		return res.Hi.Latency
+	}
+	return 0
+}
+
+// diffResults 比较 b 相对于 a 的差异
+func diffResults(a, b *testResult) *resultDiff {
+	diff := &resultDiff{
+		A:       a.Label,
+		B:       b.Label,
+		Latency: b.latency() - a.latency(),
+	}
+	if a.Download != nil && b.Download != nil && a.Download.AverageSpeed > 0 {
+		diff.DownloadChange = float64(b.Download.AverageSpeed-a.Download.AverageSpeed) / float64(a.Download.AverageSpeed)
+	}
+	if a.Upload != nil && b.Upload != nil && a.Upload.AverageSpeed > 0 {
+		diff.UploadChange = float64(b.Upload.AverageSpeed-a.Upload.AverageSpeed) / float64(a.Upload.AverageSpeed)
+	}
+	return diff
+}
+
+func (diff *resultDiff) String() string {
+	sign := "+"
+	if diff.Latency < 0 {
+		sign = ""
+	}
+	return fmt.Sprintf("%s vs %s: latency %s%s, download %+.1f%%, upload %+.1f%%", diff.B, diff.A, sign, diff.Latency, diff.DownloadChange*100, diff.UploadChange*100)
+}
+
+func formatSpeed(speed int64) string {
+	return converter.ConvertFileSize(speed, 2) + "/s"
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// envPrefix 环境变量的前缀, 如 SPEEDTEST_DOWN_PARALLEL 对应 -down_parallel
+	envPrefix = "SPEEDTEST_"
+)
+
+type (
+	// configFile 配置文件, 键为命令行参数的名称, profiles 中的设置覆盖 defaults
+	configFile struct {
+		Defaults map[string]interface{}            `json:"defaults" yaml:"defaults"`
+		Profiles map[string]map[string]interface{} `json:"profiles" yaml:"profiles"`
+	}
+
+	// configEntry 生效的参数值及其来源
+	configEntry struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Source string `json:"source"` // flag, env, file 或 default
+	}
+)
+
+var (
+	configPath    string
+	profile       string
+	isPrintConfig bool
+
+	// configSources 各参数值的来源
+	configSources = map[string]string{}
+	// knownOptions 所有子命令的参数, 配置文件中可以有当前子命令没有的参数
+	knownOptions = map[string]bool{}
+)
+
+func init() {
+	for _, cmd := range append([]*command{legacyCommand}, commands...) {
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		cmd.flags(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			knownOptions[f.Name] = true
+		})
+	}
+}
+
+// envName 参数对应的环境变量
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// lookupEnv 参数对应的环境变量, 值为空时视为没有设置
+func lookupEnv(name string) (string, bool) {
+	v := os.Getenv(envName(name))
+	return v, v != ""
+}
+
+// isConfigFlag 只能通过命令行和环境变量设置的参数
+func isConfigFlag(name string) bool {
+	return name == "config" || name == "profile" || name == "print_config"
+}
+
+// defaultConfigPath 默认的配置文件, 不存在则返回空
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	name := filepath.Join(dir, "speedtest", "config.yaml")
+	if _, err := os.Stat(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// loadConfigFile 读取配置文件, 返回 profile 生效的设置, 扩展名为 .json 时按 JSON 解析, 否则按 YAML 解析
+func loadConfigFile(name, profile string) (values map[string]interface{}, err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cf := configFile{}
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(&cf)
+	} else {
+		d := yaml.NewDecoder(bytes.NewReader(data))
+		d.KnownFields(true)
+		err = d.Decode(&cf)
+		if err == io.EOF { // 空文件
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config error: %s", err)
+	}
+
+	values = map[string]interface{}{}
+	for k, v := range cf.Defaults {
+		values[k] = v
+	}
+	if profile != "" {
+		p, ok := cf.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in %s", profile, name)
+		}
+		for k, v := range p {
+			values[k] = v
+		}
+	}
+
+	for k := range values {
+		if !knownOptions[k] || isConfigFlag(k) {
+			return nil, fmt.Errorf("unknown option %q in %s", k, name)
+		}
+	}
+	return values, nil
+}
+
+// setFlag 以配置文件或环境变量中的值设置参数, 可以重复的参数接受列表
+func setFlag(f *flag.Flag, v interface{}) error {
+	var values []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+	default:
+		values = []string{fmt.Sprint(v)}
+	}
+	if _, ok := f.Value.(*stringList); !ok && len(values) != 1 {
+		return fmt.Errorf("option %q does not accept a list", f.Name)
+	}
+	for _, value := range values {
+		err := f.Value.Set(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for option %q: %s", value, f.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyConfig 以环境变量和配置文件设置 fs 中命令行没有设置的参数,
+// 优先级为 命令行 > 环境变量 > 配置文件 > 默认值, 值为空的环境变量被忽略
+func applyConfig(fs *flag.FlagSet) (err error) {
+	fs.Visit(func(f *flag.Flag) {
+		configSources[f.Name] = "flag"
+	})
+
+	// 先确定配置文件和 profile
+	for _, name := range []string{"config", "profile", "print_config"} {
+		if configSources[name] != "" {
+			continue
+		}
+		if v, ok := lookupEnv(name); ok {
+			err = setFlag(fs.Lookup(name), v)
+			if err != nil {
+				return err
+			}
+			configSources[name] = "env " + envName(name)
+		}
+	}
+	name := configPath
+	if name == "" {
+		name = defaultConfigPath()
+	}
+	var values map[string]interface{}
+	if name != "" {
+		values, err = loadConfigFile(name, profile)
+		if err != nil {
+			return err
+		}
+	} else if profile != "" {
+		return fmt.Errorf("profile %q requires a config file", profile)
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || configSources[f.Name] != "" {
+			return
+		}
+		if v, ok := lookupEnv(f.Name); ok {
+			var ev interface{} = v
+			if _, ok := f.Value.(*stringList); ok {
+				ev = splitConfigList(v)
+			}
+			err = setFlag(f, ev)
+			configSources[f.Name] = "env " + envName(f.Name)
+			return
+		}
+		if v, ok := values[f.Name]; ok {
+			err = setFlag(f, v)
+			configSources[f.Name] = "file " + name
+			return
+		}
+		configSources[f.Name] = "default"
+	})
+	return err
+}
+
+// splitConfigList 将环境变量中逗号分隔的列表转为配置文件中的列表
+func splitConfigList(s string) (list []interface{}) {
+	for _, item := range splitList(s) {
+		list = append(list, item)
+	}
+	return
+}
+
+// effectiveConfig fs 中生效的各参数值, 按名称排序
+func effectiveConfig(fs *flag.FlagSet) (entries []*configEntry) {
+	fs.VisitAll(func(f *flag.Flag) {
+		entries = append(entries, &configEntry{
+			Name:   f.Name,
+			Value:  redactConfigValue(f.Name, f.Value.String()),
+			Source: configSources[f.Name],
+		})
+	})
+	return
+}
+
+// redactConfigValue 隐藏参数值中 URL 的密码, 如代理的用户名和密码, 逗号分隔的列表逐项处理.
+// -proxy 未指定协议时视为 http
+func redactConfigValue(name, value string) string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		s := strings.TrimSpace(item)
+		if !strings.Contains(s, "://") {
+			if name != "proxy" {
+				continue
+			}
+			s = "http://" + s
+		}
+		u, err := url.Parse(s)
+		if err != nil || u.User == nil {
+			continue
+		}
+		items[i] = u.Redacted()
+		if !strings.Contains(item, "://") {
+			items[i] = strings.TrimPrefix(items[i], "http://")
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// printConfig 输出生效的各参数值及其来源
+func printConfig(w io.Writer, fs *flag.FlagSet) {
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetColumnSeparator("")
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"OPTION", "VALUE", "SOURCE"})
+	for _, entry := range effectiveConfig(fs) {
+		table.Append([]string{entry.Name, entry.Value, entry.Source})
+	}
+	table.Render()
+}
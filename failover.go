@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"log"
+)
+
+type (
+	// failedServer 测速失败的服务器
+	failedServer struct {
+		Server *speedtestclient.SpeedtestServer `json:"server"`
+		Error  string                           `json:"error"`
+	}
+)
+
+// runWithFailover 对 server 测速, 失败时依次使用 nearby 中的下一个服务器,
+// 最多再尝试 failover 个服务器, nearby 为 nil 时在第一次失败后获取
+func runWithFailover(server *speedtestclient.SpeedtestServer, nearby speedtestclient.SpeedtestServerList, topt *testOptions, failover int) (res *testResult, err error) {
+	var (
+		candidates = speedtestclient.SpeedtestServerList{server}
+		failed     []*failedServer
+	)
+	for i := 0; i < len(candidates); i++ {
+		res, err = runTest(candidates[i], topt)
+		if err == nil {
+			res.Failovers = failed
+			return res, nil
+		}
+
+		log.Println(err)
+		failed = append(failed, &failedServer{
+			Server: candidates[i],
+			Error:  err.Error(),
+		})
+		if len(failed) > failover {
+			break
+		}
+
+		if i == 0 {
+			if nearby == nil {
+				_, nearby, err = client.GetLocalInfoAndServerList()
+				if err != nil {
+					log.Printf("get server list for failover error: %s\n", err)
+				}
+			}
+			for _, s := range nearby {
+				if s.Host != server.Host {
+					candidates = append(candidates, s)
+				}
+			}
+		}
+		if i+1 < len(candidates) {
+			log.Printf("failover to the next server, %s\n", candidates[i+1])
+		}
+	}
+
+	if res == nil {
+		res = &testResult{}
+	}
+	// 保留最后一个服务器的部分结果, 以 Error 标记失败
+	err = fmt.Errorf("all %d servers failed, last error: %s", len(failed), failed[len(failed)-1].Error)
+	res.Error = err.Error()
+	res.Failovers = failed
+	return res, err
+}
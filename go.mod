@@ -1,9 +1,16 @@
 module github.com/iikira/speedtest
 
-go 1.12
+go 1.21
 
 require (
 	github.com/iikira/iikira-go-utils v0.0.0-20220222150209-a6338eee669f
 	github.com/olekukonko/tablewriter v0.0.5
 	golang.org/x/net v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/mattn/go-runewidth v0.0.10 // indirect
+	github.com/rivo/uniseg v0.1.0 // indirect
+	golang.org/x/text v0.10.0 // indirect
 )
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// historyRecord 测速记录, 每行一个 json
+	historyRecord struct {
+		Time       time.Time     `json:"time"`
+		Server     string        `json:"server"`
+		Label      string        `json:"label,omitempty"` // 比较或合计测速中的名称, 如网卡, 拥塞控制
+		RemoteAddr string        `json:"remote_addr,omitempty"`
+		Latency    time.Duration `json:"latency,omitempty"` // PING 平均值, 没有则用 HI
+		Download   int64         `json:"download,omitempty"`
+		Upload     int64         `json:"upload,omitempty"`
+		Error      string        `json:"error,omitempty"`
+	}
+)
+
+// newHistoryRecord 由测速结果生成测速记录
+func newHistoryRecord(res *testResult) *historyRecord {
+	record := &historyRecord{
+		Time:       time.Now(),
+		RemoteAddr: res.RemoteAddr,
+		Latency:    res.latency(),
+		Error:      res.Error,
+	}
+	if res.Server != nil {
+		record.Server = serverLabel(res.Server)
+	}
+	if res.Label != record.Server {
+		record.Label = res.Label
+	}
+	if res.Download != nil {
+		record.Download = res.Download.AverageSpeed
+	}
+	if res.Upload != nil {
+		record.Upload = res.Upload.AverageSpeed
+	}
+	return record
+}
+
+// recordHistory 设置了 history_file 时, 将测速结果追加到文件, 出错只输出日志
+func recordHistory(results ...*testResult) {
+	if historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(historyFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("record history error: %s\n", err)
+		return
+	}
+	defer f.Close()
+
+	e := json.NewEncoder(f)
+	for _, res := range results {
+		if res == nil {
+			continue
+		}
+		err = e.Encode(newHistoryRecord(res))
+		if err != nil {
+			log.Printf("record history error: %s\n", err)
+			return
+		}
+	}
+}
+
+// readHistory 读取测速记录, 跳过无法解析的行
+func readHistory(name string) (records []*historyRecord, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record := &historyRecord{}
+		if json.Unmarshal([]byte(line), record) != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// printHistory 输出测速记录
+func printHistory(w io.Writer, records []*historyRecord) {
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetColumnSeparator("")
+	table.SetHeader([]string{"TIME", "SERVER", "TEST", "REMOTE ADDR", "LATENCY", "DOWNLOAD", "UPLOAD", "ERROR"})
+	for _, record := range records {
+		row := []string{record.Time.Format("2006-01-02 15:04:05"), record.Server, record.Label, record.RemoteAddr, "", "", "", record.Error}
+		if record.Latency > 0 {
+			row[4] = record.Latency.String()
+		}
+		if record.Download > 0 {
+			row[5] = formatSpeed(record.Download)
+		}
+		if record.Upload > 0 {
+			row[6] = formatSpeed(record.Upload)
+		}
+		table.Append(row)
+	}
+	table.Render()
+}
+
+func cmdHistory(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	if historyFile == "" {
+		return usageErrorf("history_file is not set")
+	}
+	records, err := readHistory(historyFile)
+	if err != nil {
+		return fmt.Errorf("read history error: %s", err)
+	}
+	if historyCount > 0 && len(records) > historyCount {
+		records = records[len(records)-historyCount:]
+	}
+	if isJSON {
+		printJSON(records)
+		return nil
+	}
+	printHistory(os.Stdout, records)
+	return nil
+}
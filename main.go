@@ -1,9 +1,7 @@
 package main
 
 import (
-	"flag"
 	"fmt"
-	"github.com/iikira/iikira-go-utils/requester"
 	"github.com/iikira/iikira-go-utils/utils/converter"
 	"github.com/iikira/speedtest/speedtestclient"
 	"github.com/iikira/speedtest/speedtestutil/interfaceutil"
@@ -11,14 +9,14 @@ import (
 	"net"
 	"os"
 	"strings"
-	"time"
 )
 
 var (
 	isListAll           bool
 	isListNearby        bool
 	isGetLocalInfo      bool
-	speedtestServerID   int
+	isInventory         bool
+	speedtestServerID   string
 	speedtestServerHost string
 	uploadParallel      int
 	downloadParallel    int
@@ -31,71 +29,172 @@ var (
 	disablePing         bool
 	sourceAddr          string
 	sourceInterface     string
+	bindDevice          string
+	fwmark              int
 	proxy               string
+	transport           string
+	isHTTPMode          bool
+	isEnvProxy          bool
+	isAllInterfaces     bool
+	interfaces          string
+	isConcurrent        bool
+	isBindInterfaces    bool
+	isJSON              bool
+	isIPv4Only          bool
+	isIPv6Only          bool
+	isDualStack         bool
+	congestion          string
+	recvBuffer          int
+	sendBuffer          int
+	mss                 int
+	noDelay             string
+	compareCC           string
+	bufferSize          int
+	isZeroCopy          bool
+	payload             string
+	isSampleDownload    bool
+	dscp                string
+	compareDSCP         string
+	isMPTCP             bool
+	isCompareMPTCP      bool
+	resolves            stringList
+	failover            int
+	serversFile         string
+	isInterleave        bool
+	isAggregate         bool
+	planFile            string
+	dnsServer           string
+	historyFile         string
+	historyCount        int
+	listenAddr          string
+
+	// network tcp, tcp4 或 tcp6
+	network = "tcp"
 
 	refreshInterval string
 
 	client    *speedtestclient.SpeedtestClient
 	localAddr *net.TCPAddr
+	sockOpts  speedtestclient.SocketOptions
 )
 
-func init() {
-	flag.BoolVar(&isListAll, "list_all", false, "list all Speedtest.net server, priority 0")
-	flag.BoolVar(&isListNearby, "list_nearby", false, "list nearby Speedtest.net server, priority 1")
-	flag.BoolVar(&isGetLocalInfo, "local_info", false, "get local info, e.g. ISP")
-	flag.IntVar(&speedtestServerID, "server_id", 0, "Speedtest.net server id, priority 2")
-	flag.StringVar(&speedtestServerHost, "server_host", "", "Speedtest.net server host, priority 3")
-	flag.IntVar(&uploadParallel, "up_parallel", 2, "Max upload parallel")
-	flag.IntVar(&downloadParallel, "down_parallel", 2, "Max download parallel")
-	flag.StringVar(&uploadTime, "up_time", "15s", "Upload time")
-	flag.StringVar(&downloadTime, "down_time", "15s", "Download time")
-	flag.IntVar(&pingTimes, "ping_times", 3, "Times of PING")
-	flag.BoolVar(&disableUpload, "disable_up", false, "Disable UPLOAD")
-	flag.BoolVar(&disableDownload, "disable_down", false, "Disable DOWNLOAD")
-	flag.BoolVar(&disableHi, "disable_hi", false, "Disable HI")
-	flag.BoolVar(&disablePing, "disable_ping", false, "Disable PING")
-	flag.StringVar(&sourceAddr, "source_addr", "", "Local source address, priority 0")
-	flag.StringVar(&sourceInterface, "source_interface", "", "Local source interface, priority 1")
-	flag.StringVar(&proxy, "proxy", "", "http or socks proxy address")
-	flag.StringVar(&refreshInterval, "refresh_interval", "1s", "Upload or Download refresh interval")
-	flag.Parse()
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// setupClient 根据连接相关的参数创建 client
+func setupClient() (err error) {
+	if isIPv4Only && isIPv6Only {
+		return fmt.Errorf("-4 and -6 are mutually exclusive")
+	} else if isIPv4Only {
+		network = "tcp4"
+	} else if isIPv6Only {
+		network = "tcp6"
+	}
 
 	client = speedtestclient.NewSpeedtestClient()
 	client.SetProxy(proxy)
-}
-
-func main() {
-	var err error
 
 	// set local source addr
 	if sourceAddr != "" {
-		localAddr = &net.TCPAddr{
-			IP: net.ParseIP(sourceAddr),
+		// 链路本地的 IPv6 地址可以带有 zone, 如 fe80::1%eth0
+		localAddr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(sourceAddr, "0"))
+		if err != nil {
+			return fmt.Errorf("parse source_addr error: %s", err)
 		}
-		requester.SetLocalTCPAddrList(sourceAddr)
 	} else if sourceInterface != "" {
 		localAddr, err = interfaceutil.GetAvaliableLocalTCPAddr(sourceInterface)
 		if err != nil {
-			log.Fatalf("get avaliable interface source addr error: %s, please specify source_addr\n", err)
+			return fmt.Errorf("get avaliable interface source addr error: %s, please specify source_addr", err)
 		}
-		requester.SetLocalTCPAddrList(localAddr.IP.String())
+	}
+	if localAddr != nil {
+		// 获取配置的请求也使用该地址, 保留 IPv6 的 zone
+		client.SetLocalAddr(localAddr)
 	}
 
+	err = parseResolves()
+	if err != nil {
+		return err
+	}
+
+	// set socket options
+	sockOpts = speedtestclient.SocketOptions{
+		BindDevice: bindDevice,
+		Mark:       fwmark,
+	}
+	if dscp != "" {
+		v, err := speedtestclient.ParseDSCP(dscp)
+		if err != nil {
+			return err
+		}
+		sockOpts.DSCP = &v
+	}
+	if sockOpts != (speedtestclient.SocketOptions{}) {
+		client.SetSocketOptions(&sockOpts)
+	}
+	return nil
+}
+
+// setupTest 解析下载和上传相关的参数
+func setupTest() (err error) {
+	err = parseDurations()
+	if err != nil {
+		return err
+	}
+	if isJSON {
+		out = os.Stderr
+	}
+	err = parseTCPOptions()
+	if err != nil {
+		return err
+	}
+	if isHTTPMode && (isIPv4Only || isIPv6Only || isDualStack) {
+		return fmt.Errorf("-4, -6 and dual_stack are not supported in http mode")
+	}
+	uploadPayload, err = speedtestclient.ParsePayload(payload)
+	if err != nil {
+		return fmt.Errorf("parse payload error: %s", err)
+	}
+	return parseThresholds()
+}
+
+// cmdLegacy 不使用子命令时, 按旧的优先级执行 list_all, inventory, list_nearby, local_info 或测速
+func cmdLegacy(args []string) (err error) {
 	if isListAll {
 		servList, err := client.GetAllServerList()
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 		fmt.Println("All Server List: ")
 		servList.PrintTo(os.Stdout)
-		return
+		return nil
+	}
+
+	if isInventory {
+		var servList speedtestclient.SpeedtestServerList
+		if speedtestServerHost != "" {
+			for _, host := range strings.Split(speedtestServerHost, ",") {
+				servList = append(servList, &speedtestclient.SpeedtestServer{
+					Host: strings.TrimSpace(host),
+				})
+			}
+		} else {
+			_, servList, err = client.GetLocalInfoAndServerList()
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Println("Server Inventory: ")
+		client.Inventory(servList).PrintTo(os.Stdout)
+		return nil
 	}
 
 	// list server or local info
 	if isListNearby || isGetLocalInfo {
 		li, servList, err := client.GetLocalInfoAndServerList()
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 		if isListNearby {
 			fmt.Println("Nearby Server List: ")
@@ -105,112 +204,240 @@ func main() {
 			fmt.Println("Local Info: ")
 			li.PrintTo(os.Stdout)
 		}
-		return
+		return nil
 	}
 
-	// query server host by id
-	if speedtestServerHost == "" {
-		if speedtestServerID != 0 {
-			servList, err := client.GetAllServerList()
-			if err != nil {
-				log.Fatalln(err)
-			}
-			server := servList.FindByID(speedtestServerID)
-			if server == nil {
-				log.Fatalf("server host not found, id: %d\n", speedtestServerID)
-			}
+	return cmdRun(args)
+}
 
-			speedtestServerHost = server.Host
+// selectServer 返回指定的服务器, 没有指定则返回最近的服务器, nearby 为附近的服务器, 用于失败时切换
+func selectServer(servers speedtestclient.SpeedtestServerList) (server *speedtestclient.SpeedtestServer, nearby speedtestclient.SpeedtestServerList, err error) {
+	if len(servers) > 0 {
+		server = servers[0]
+		if server.ID != 0 {
 			log.Printf("server found, %s\n", server)
-		} else if speedtestServerHost == "" {
-			// default, find host
-			_, servList, err := client.GetLocalInfoAndServerList()
-			if err != nil {
-				log.Fatalln(err)
-			}
-			if len(servList) == 0 {
-				log.Fatalf("server not found\n")
-			}
-
-			speedtestServerHost = servList[0].Host
-			log.Printf("server found, %s\n", servList[0])
 		}
+		return server, nil, nil
 	}
 
-	withHost := client.WithHost(speedtestServerHost)
+	// default, find host
+	_, servList, err := client.GetLocalInfoAndServerList()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(servList) == 0 {
+		return nil, nil, noServerErrorf("server not found")
+	}
+
+	server = servList[0]
+	log.Printf("server found, %s\n", server)
+	return server, servList, nil
+}
+
+// cmdRun 测速
+func cmdRun(args []string) (err error) {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	err = setupTest()
+	if err != nil {
+		return usageError(err)
+	}
 
-	// set proxy
-	if proxy != "" {
-		err = withHost.SetSocks5Proxy(proxy)
+	// run test plan
+	if planFile != "" {
+		if hasThresholds() {
+			return usageErrorf("thresholds are not supported with plan")
+		}
+		plan, err := loadPlan(planFile)
 		if err != nil {
-			log.Fatalf("set proxy error: %s\n", err)
+			return usageError(err)
+		}
+		res, err := runPlan(plan)
+		if isJSON {
+			printJSON(res)
 		}
+		return err
 	}
 
-	// set local addr
-	withHost.SetLocalAddr(localAddr)
-
-	// hi
-	if !disableHi {
-		hiRes, err := withHost.HI()
+	// query server host by id
+	servers, err := selectServers()
+	if err != nil {
+		return err
+	}
+	if len(servers) > 1 {
+		err = checkMultiServerFlags()
 		if err != nil {
-			log.Fatalf("HI errro: %s\n", err)
+			return err
 		}
-		fmt.Printf("HI success, latency: %s\n", hiRes.Latency)
 	}
-
-	// ping
-	if !disablePing {
-		pingRes, err := withHost.Ping(pingTimes, 1*time.Second, func(seq int, latency time.Duration) {
-			log.Printf("[%d] PING %s\n", seq, latency)
-		})
+	if isAggregate {
+		if len(servers) < 2 {
+			return usageErrorf("aggregate requires at least two servers")
+		}
+		if maxLatencyTime > 0 || maxLossRatio >= 0 {
+			return usageErrorf("max_latency and max_loss are not supported with aggregate")
+		}
+		err = aggregateParallel(len(servers))
 		if err != nil {
-			log.Fatalf("PING errro: %s\n", err)
+			return err
 		}
-
-		fmt.Printf("PING RES: min/avg/max/median = %s/%s/%s/%s\n", pingRes.Min, pingRes.Average, pingRes.Max, pingRes.Median)
+		res, err := runAggregate(servers)
+		// 只检查和记录下载和上传速度
+		checked := &testResult{Label: "aggregate", Download: res.Download, Upload: res.Upload, Error: res.Error}
+		if err == nil {
+			err = checkResults(checked)
+			res.Violations = checked.Violations
+		}
+		if isJSON {
+			printJSON(res)
+		}
+		recordHistory(checked)
+		return err
+	}
+	if len(servers) > 1 {
+		report := runCampaign(servers, isInterleave)
+		fmt.Fprintln(out, "Server Comparison: ")
+		report.PrintTo(out)
+		for _, diff := range report.Diffs {
+			fmt.Fprintln(out, diff)
+		}
+		if report.Summary != "" {
+			fmt.Fprintln(out, report.Summary)
+		}
+		err = checkResults(report.Results...)
+		if isJSON {
+			printJSON(report)
+		}
+		recordHistory(report.Results...)
+		return err
 	}
 
-	opt := speedtestclient.UpDownloadOption{}
-	opt.CallbackInterval, err = time.ParseDuration(strings.ToLower(refreshInterval))
+	server, nearby, err := selectServer(servers)
 	if err != nil {
-		log.Fatalf("parse refresh_interval error: %s\n", err)
+		return err
 	}
 
-	if !disableDownload {
-		opt.Timeout, err = time.ParseDuration(strings.ToLower(downloadTime))
+	if isAllInterfaces {
+		uplinks, err := listUplinks(splitList(interfaces))
 		if err != nil {
-			log.Fatalf("DOWNLOAD: parse down_time error: %s\n", err)
+			return fmt.Errorf("list interfaces error: %s", err)
+		}
+		if len(uplinks) == 0 {
+			return fmt.Errorf("no usable interface found")
 		}
 
-		opt.Parallel = downloadParallel
-		downRes, err := withHost.Download(&opt, upDownCallback("↓"))
-		if err != nil {
-			log.Fatalf("DOWNLOAD error: %s\n", err)
+		report := runUplinks(server, uplinks, isConcurrent)
+		fmt.Fprintln(out, "Uplink Comparison: ")
+		report.PrintTo(out)
+		err = checkResults(report.Results...)
+		if isJSON {
+			printJSON(report)
 		}
+		recordHistory(report.Results...)
+		return err
+	}
 
-		printRes("DOWNLOAD", downRes)
+	if isDualStack {
+		report := runComparison("dual_stack", server, []*testOptions{
+			{label: "ipv4", network: "tcp4"},
+			{label: "ipv6", network: "tcp6"},
+		}, false)
+		fmt.Fprintln(out, "Dual Stack Comparison: ")
+		return printDiffFirst(report)
 	}
 
-	if !disableUpload {
-		opt.Timeout, err = time.ParseDuration(strings.ToLower(uploadTime))
-		if err != nil {
-			log.Fatalf("UPLOAD: parse up_time error: %s\n", err)
+	if compareCC != "" {
+		ccs := splitList(compareCC)
+		topts := make([]*testOptions, 0, len(ccs))
+		for _, cc := range ccs {
+			tcpOpts := tcpOptions
+			tcpOpts.Congestion = cc
+			topts = append(topts, &testOptions{
+				label:     cc,
+				localAddr: localAddr,
+				tcpOpts:   &tcpOpts,
+			})
 		}
+		report := runComparison("congestion", server, topts, false)
+		fmt.Fprintln(out, "Congestion Control Comparison: ")
+		return printDiffFirst(report)
+	}
 
-		opt.Parallel = uploadParallel
-		upRes, err := withHost.Upload(&opt, upDownCallback("↑"))
-		if err != nil {
-			log.Fatalf("UPLOAD error: %s\n", err)
+	if compareDSCP != "" {
+		if isHTTPMode {
+			return usageErrorf("compare_dscp is not supported in http mode")
+		}
+		names := splitList(compareDSCP)
+		topts := make([]*testOptions, 0, len(names))
+		for _, name := range names {
+			v, err := speedtestclient.ParseDSCP(name)
+			if err != nil {
+				return usageError(err)
+			}
+			opts := sockOpts
+			opts.DSCP = &v
+			topts = append(topts, &testOptions{
+				label:     "dscp=" + name,
+				localAddr: localAddr,
+				sockOpts:  &opts,
+			})
 		}
+		report := runComparison("dscp", server, topts, false)
+		fmt.Fprintln(out, "DSCP Comparison: ")
+		return printDiffFirst(report)
+	}
 
-		printRes("UPLOAD", upRes)
+	if isCompareMPTCP {
+		if isHTTPMode {
+			return usageErrorf("compare_mptcp is not supported in http mode")
+		}
+		useTCP, useMPTCP := false, true
+		report := runComparison("mptcp", server, []*testOptions{
+			{label: "tcp", localAddr: localAddr, mptcp: &useTCP},
+			{label: "mptcp", localAddr: localAddr, mptcp: &useMPTCP},
+		}, false)
+		fmt.Fprintln(out, "MPTCP Comparison: ")
+		return printDiffFirst(report)
 	}
 
+	res, err := runWithFailover(server, nearby, &testOptions{
+		localAddr: localAddr,
+	}, failover)
+	if err == nil {
+		err = checkResults(res)
+	}
+	if isJSON {
+		printJSON(res)
+	}
+	recordHistory(res)
+	return err
+}
+
+// printDiffFirst 输出比较的结果, 以及相对于第一个结果的差异, 检查阈值并记录到 history_file
+func printDiffFirst(report *comparisonReport) error {
+	report.PrintTo(out)
+	report.diffFirst()
+	for _, diff := range report.Diffs {
+		fmt.Fprintln(out, diff)
+	}
+	err := checkResults(report.Results...)
+	if isJSON {
+		printJSON(report)
+	}
+	recordHistory(report.Results...)
+	return err
 }
 
 func printRes(op string, res *speedtestclient.UpDownloadRes) {
-	fmt.Printf(op+" RES: min/avg/max/median = %s/%s/%s/%s per second\n", converter.ConvertFileSize(res.MinSpeedPerSecond, 2), converter.ConvertFileSize(res.AverageSpeed, 2), converter.ConvertFileSize(res.MaxSpeedPerSecond, 2), converter.ConvertFileSize(res.MedianSpeed, 2))
+	fmt.Fprintf(out, op+" RES: min/avg/max/median = %s/%s/%s/%s per second\n", converter.ConvertFileSize(res.MinSpeedPerSecond, 2), converter.ConvertFileSize(res.AverageSpeed, 2), converter.ConvertFileSize(res.MaxSpeedPerSecond, 2), converter.ConvertFileSize(res.MedianSpeed, 2))
+	if res.MPTCP != nil {
+		fmt.Fprintf(out, op+" MPTCP: %d/%d streams negotiated\n", res.MPTCP.Negotiated, len(res.MPTCP.Streams))
+	}
+	if res.TCPInfo != nil {
+		agg := res.TCPInfo.Aggregate
+		fmt.Fprintf(out, op+" TCP: %d streams, congestion: %s, rtt/rttvar = %s/%s, retransmits: %d, cwnd: %d, delivery/pacing rate = %s/%s per second\n", len(res.TCPInfo.Streams), agg.Congestion, agg.RTT, agg.RTTVar, agg.Retransmits, agg.SndCwnd, converter.ConvertFileSize(agg.DeliveryRate, 2), converter.ConvertFileSize(agg.PacingRate, 2))
+	}
 }
 
 func upDownCallback(character string) speedtestclient.UpDownloadCallback {
@@ -223,5 +450,8 @@ func upDownCallback(character string) speedtestclient.UpDownloadCallback {
 			elapsed/1e7*1e7,
 			left/1e7*1e7,
 		)
+		for _, h := range statistic.Hosts() {
+			log.Printf("%s [%s] %s %s/s\n", character, h.Host, converter.ConvertFileSize(h.TransferSize(), 2), converter.ConvertFileSize(h.SpeedPerSecond(), 2))
+		}
 	}
 }
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// testPlan 测速计划, 描述服务器的选择规则和测速的步骤, 格式为 JSON 或 YAML
+	testPlan struct {
+		Name    string      `json:"name" yaml:"name"`
+		Servers planServers `json:"servers" yaml:"servers"`
+		Steps   []*planStep `json:"steps" yaml:"steps"`
+	}
+
+	// planServers 服务器的选择规则, 都没有设置则选择最近的服务器
+	planServers struct {
+		IDs     []int    `json:"ids" yaml:"ids"`
+		Hosts   []string `json:"hosts" yaml:"hosts"`
+		Nearest int      `json:"nearest" yaml:"nearest"` // 选择附近的前 n 个服务器
+		Match   string   `json:"match" yaml:"match"`     // 只选择 name 或 sponsor 包含该字符串的附近的服务器
+	}
+
+	// planStep 测速的一个步骤
+	planStep struct {
+		Type           string      `json:"type" yaml:"type"`         // dns, hi, ping, download, upload, pause 或 repeat
+		Count          int         `json:"count" yaml:"count"`       // ping 或 repeat 的次数
+		Interval       string      `json:"interval" yaml:"interval"` // ping 的间隔
+		Time           string      `json:"time" yaml:"time"`         // download, upload 或 pause 的时长
+		Parallel       int         `json:"parallel" yaml:"parallel"`
+		Congestion     string      `json:"congestion" yaml:"congestion"`
+		RecvBuffer     int         `json:"rcvbuf" yaml:"rcvbuf"`
+		SendBuffer     int         `json:"sndbuf" yaml:"sndbuf"`
+		MSS            int         `json:"mss" yaml:"mss"`
+		BufferSize     int         `json:"buffer_size" yaml:"buffer_size"`
+		ZeroCopy       bool        `json:"zero_copy" yaml:"zero_copy"`
+		Payload        string      `json:"payload" yaml:"payload"`
+		SampleDownload bool        `json:"sample_download" yaml:"sample_download"`
+		Steps          []*planStep `json:"steps" yaml:"steps"` // repeat 重复的步骤
+
+		interval time.Duration
+		duration time.Duration
+		payload  *speedtestclient.Payload
+	}
+
+	// planResult 执行测速计划的结果
+	planResult struct {
+		Name    string              `json:"name,omitempty"`
+		Servers []*planServerResult `json:"servers"`
+	}
+
+	// planServerResult 对一个服务器执行测速计划的结果
+	planServerResult struct {
+		Server     *speedtestclient.SpeedtestServer `json:"server"`
+		RemoteAddr string                           `json:"remote_addr,omitempty"`
+		Family     string                           `json:"family,omitempty"`
+		Steps      []*stepResult                    `json:"steps"`
+		// Connections 各连接建立时各阶段用时的分布
+		Connections *speedtestclient.ConnTimingStats `json:"connections,omitempty"`
+		Error       string                           `json:"error,omitempty"`
+	}
+
+	// stepResult 一个步骤的结果
+	stepResult struct {
+		Type      string                         `json:"type"`
+		Iteration []int                          `json:"iteration,omitempty"` // 所在的各层 repeat 的第几次, 从 1 开始
+		DNS       *speedtestclient.DNSRes        `json:"dns,omitempty"`
+		Hi        *speedtestclient.HIRes         `json:"hi,omitempty"`
+		Ping      *speedtestclient.PingRes       `json:"ping,omitempty"`
+		Download  *speedtestclient.UpDownloadRes `json:"download,omitempty"`
+		Upload    *speedtestclient.UpDownloadRes `json:"upload,omitempty"`
+	}
+
+	// planRun 对一个服务器执行测速计划的状态, 各步骤由 test 执行
+	planRun struct {
+		test      *testRun
+		res       *planServerResult
+		iteration []int
+	}
+)
+
+// loadPlan 读取测速计划, 扩展名为 .json 时按 JSON 解析, 否则按 YAML 解析
+func loadPlan(name string) (plan *testPlan, err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	plan = &testPlan{}
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(plan)
+	} else {
+		d := yaml.NewDecoder(bytes.NewReader(data))
+		d.KnownFields(true)
+		err = d.Decode(plan)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse plan error: %s", err)
+	}
+
+	if len(plan.Steps) == 0 {
+		return nil, fmt.Errorf("plan has no steps")
+	}
+	for _, step := range plan.Steps {
+		err = step.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// validate 检查步骤并解析其中的时长等设置
+func (step *planStep) validate() (err error) {
+	if step.Interval != "" {
+		step.interval, err = time.ParseDuration(step.Interval)
+		if err != nil {
+			return fmt.Errorf("%s: parse interval error: %s", step.Type, err)
+		}
+	}
+	if step.Time != "" {
+		step.duration, err = time.ParseDuration(step.Time)
+		if err != nil {
+			return fmt.Errorf("%s: parse time error: %s", step.Type, err)
+		}
+	}
+	if step.Payload != "" {
+		step.payload, err = speedtestclient.ParsePayload(step.Payload)
+		if err != nil {
+			return fmt.Errorf("%s: %s", step.Type, err)
+		}
+	}
+
+	switch step.Type {
+	case "dns", "hi", "ping", "download", "upload":
+		if isHTTPMode && (step.Type == "dns" || step.Type == "hi") {
+			return fmt.Errorf("%s is not supported in http mode", step.Type)
+		}
+		if isHTTPMode && (step.Congestion != "" || step.RecvBuffer != 0 || step.SendBuffer != 0 || step.MSS != 0) {
+			return fmt.Errorf("%s: TCP options are not supported in http mode", step.Type)
+		}
+	case "pause":
+		if step.duration <= 0 {
+			return fmt.Errorf("pause: time is required")
+		}
+	case "repeat":
+		if step.Count < 1 || len(step.Steps) == 0 {
+			return fmt.Errorf("repeat: count and steps are required")
+		}
+		for _, sub := range step.Steps {
+			err = sub.validate()
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown step type %q, expect dns, hi, ping, download, upload, pause or repeat", step.Type)
+	}
+	return nil
+}
+
+// selectServers 根据规则选择服务器
+func (ps *planServers) selectServers() (servers speedtestclient.SpeedtestServerList, err error) {
+	hosts := make([]string, 0, len(ps.Hosts))
+	for _, host := range ps.Hosts {
+		hosts = append(hosts, serverHost(host))
+	}
+	servers, err = lookupServers(ps.IDs, hosts)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) > 0 && ps.Nearest == 0 && ps.Match == "" {
+		return servers, nil
+	}
+
+	_, servList, err := client.GetLocalInfoAndServerList()
+	if err != nil {
+		return nil, err
+	}
+	nearest := ps.Nearest
+	if nearest <= 0 {
+		nearest = 1
+	}
+	for _, server := range servList {
+		if nearest == 0 {
+			break
+		}
+		if ps.Match != "" && !strings.Contains(server.Name, ps.Match) && !strings.Contains(server.Sponsor, ps.Match) {
+			continue
+		}
+		servers = append(servers, server)
+		nearest--
+	}
+	if len(servers) == 0 {
+		return nil, noServerErrorf("server not found")
+	}
+	return servers, nil
+}
+
+// runPlan 对选择的各个服务器依次执行测速计划, 返回合并的结果和第一个错误,
+// 每个服务器的最后一次结果记录到 history_file
+func runPlan(plan *testPlan) (res *planResult, err error) {
+	res = &planResult{
+		Name: plan.Name,
+	}
+	servers, err := plan.Servers.selectServers()
+	if err != nil {
+		return res, err
+	}
+
+	history := make([]*testResult, 0, len(servers))
+	for _, server := range servers {
+		topt := &testOptions{
+			localAddr: localAddr,
+		}
+		if len(servers) > 1 {
+			topt.label = serverLabel(server)
+		}
+		run := &planRun{
+			test: newTestRun(server, topt),
+			res: &planServerResult{
+				Server: server,
+			},
+		}
+		res.Servers = append(res.Servers, run.res)
+
+		log.Printf("%splan %s, server %s\n", run.test.prefix, plan.Name, server)
+		err = run.test.err
+		if err == nil {
+			err = run.steps(plan.Steps)
+		}
+		testRes, _ := run.test.finish()
+		run.res.RemoteAddr, run.res.Family, run.res.Connections = testRes.RemoteAddr, testRes.Family, testRes.Connections
+		if err != nil {
+			log.Println(err)
+			run.res.Error = err.Error()
+			testRes.Error = run.res.Error
+		}
+		history = append(history, testRes)
+	}
+	recordHistory(history...)
+
+	for _, serverRes := range res.Servers {
+		if serverRes.Error != "" {
+			return res, fmt.Errorf("plan failed: %s", serverRes.Error)
+		}
+	}
+	return res, nil
+}
+
+// steps 依次执行 steps, 出错时停止
+func (run *planRun) steps(steps []*planStep) error {
+	for _, step := range steps {
+		err := run.step(step)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step 执行一个步骤
+func (run *planRun) step(step *planStep) (err error) {
+	if step.Type == "pause" {
+		time.Sleep(step.duration)
+		return nil
+	}
+	if step.Type == "repeat" {
+		run.iteration = append(run.iteration, 0)
+		defer func() {
+			run.iteration = run.iteration[:len(run.iteration)-1]
+		}()
+		for i := 1; i <= step.Count; i++ {
+			run.iteration[len(run.iteration)-1] = i
+			err = run.steps(step.Steps)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	res := &stepResult{
+		Type:      step.Type,
+		Iteration: append([]int(nil), run.iteration...),
+	}
+	test := run.test
+	switch step.Type {
+	case "dns":
+		err = test.dns()
+		res.DNS = test.res.DNS
+	case "hi":
+		err = test.doHi()
+		res.Hi = test.res.Hi
+	case "ping":
+		count, interval := step.Count, step.interval
+		if count <= 0 {
+			count = pingTimes
+		}
+		if step.Interval == "" {
+			interval = 1 * time.Second
+		}
+		err = test.doPing(count, interval)
+		res.Ping = test.res.Ping
+	case "download":
+		err = test.doDownload(run.option(step, downloadDuration, downloadParallel))
+		res.Download = test.res.Download
+	case "upload":
+		err = test.doUpload(run.option(step, uploadDuration, uploadParallel))
+		res.Upload = test.res.Upload
+	}
+	if err != nil {
+		return err
+	}
+	run.res.Steps = append(run.res.Steps, res)
+	return nil
+}
+
+// option 下载或上传的设置, 步骤中没有设置的使用命令行参数
+func (run *planRun) option(step *planStep, duration time.Duration, parallel int) speedtestclient.UpDownloadOption {
+	opt := run.test.opt
+	opt.Timeout, opt.Parallel = duration, parallel
+	if step.duration > 0 {
+		opt.Timeout = step.duration
+	}
+	if step.Parallel > 0 {
+		opt.Parallel = step.Parallel
+	}
+	if step.Congestion != "" {
+		opt.Congestion = step.Congestion
+	}
+	if step.RecvBuffer > 0 {
+		opt.RecvBuffer = step.RecvBuffer
+	}
+	if step.SendBuffer > 0 {
+		opt.SendBuffer = step.SendBuffer
+	}
+	if step.MSS > 0 {
+		opt.MSS = step.MSS
+	}
+	if step.BufferSize > 0 {
+		opt.BufferSize = step.BufferSize
+	}
+	if step.payload != nil {
+		opt.Payload = step.payload
+	}
+	opt.ZeroCopy = opt.ZeroCopy || step.ZeroCopy
+	opt.SampleDownload = opt.SampleDownload || step.SampleDownload
+	return opt
+}
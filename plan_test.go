@@ -0,0 +1,229 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTempFile 将 content 写入临时目录中的 name 文件
+func writeTempFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPlan(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		content string
+		err     string // 为空表示应当成功
+	}{
+		{
+			name: "yaml",
+			file: "plan.yaml",
+			content: `
+name: nightly
+servers:
+  ids: [1234]
+  hosts: ["speedtest.example.com:8080"]
+steps:
+  - type: ping
+    count: 5
+    interval: 200ms
+  - type: download
+    time: 5s
+    parallel: 4
+`,
+		},
+		{
+			name:    "json",
+			file:    "plan.json",
+			content: `{"name": "nightly", "servers": {"nearest": 3}, "steps": [{"type": "hi"}, {"type": "upload", "time": "5s", "payload": "zero"}]}`,
+		},
+		{
+			name:    "yml extension",
+			file:    "plan.yml",
+			content: "steps:\n  - type: dns\n",
+		},
+		{
+			name:    "yaml unknown field",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: ping\n    times: 5\n",
+			err:     "field times not found",
+		},
+		{
+			name:    "json unknown field",
+			file:    "plan.json",
+			content: `{"steps": [{"type": "ping", "times": 5}]}`,
+			err:     `unknown field "times"`,
+		},
+		{
+			name:    "json unknown server field",
+			file:    "plan.json",
+			content: `{"servers": {"id": [1]}, "steps": [{"type": "ping"}]}`,
+			err:     `unknown field "id"`,
+		},
+		{
+			name:    "invalid json",
+			file:    "plan.json",
+			content: `{"steps": [`,
+			err:     "parse plan error",
+		},
+		{
+			name:    "no steps",
+			file:    "plan.yaml",
+			content: "name: empty\n",
+			err:     "plan has no steps",
+		},
+		{
+			name:    "unknown step type",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: traceroute\n",
+			err:     `unknown step type "traceroute"`,
+		},
+		{
+			name:    "invalid time",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: download\n    time: 5 seconds\n",
+			err:     "download: parse time error",
+		},
+		{
+			name:    "invalid payload",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: upload\n    payload: ones\n",
+			err:     "upload: invalid payload",
+		},
+		{
+			name: "nested repeat",
+			file: "plan.yaml",
+			content: `
+steps:
+  - type: repeat
+    count: 3
+    steps:
+      - type: ping
+      - type: repeat
+        count: 2
+        steps:
+          - type: download
+            time: 1s
+          - type: pause
+            time: 500ms
+`,
+		},
+		{
+			name: "invalid step in nested repeat",
+			file: "plan.yaml",
+			content: `
+steps:
+  - type: repeat
+    count: 3
+    steps:
+      - type: repeat
+        count: 2
+        steps:
+          - type: pause
+`,
+			err: "pause: time is required",
+		},
+		{
+			name:    "repeat without count",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: repeat\n    steps:\n      - type: ping\n",
+			err:     "repeat: count and steps are required",
+		},
+		{
+			name:    "repeat without steps",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: repeat\n    count: 2\n",
+			err:     "repeat: count and steps are required",
+		},
+		{
+			name:    "pause without time",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: pause\n",
+			err:     "pause: time is required",
+		},
+		{
+			name:    "pause with zero time",
+			file:    "plan.yaml",
+			content: "steps:\n  - type: pause\n    time: 0s\n",
+			err:     "pause: time is required",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadPlan(writeTempFile(t, c.file, c.content))
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestLoadPlanParsed(t *testing.T) {
+	plan, err := loadPlan(writeTempFile(t, "plan.yaml", `
+name: nightly
+servers:
+  ids: [1, 2]
+  match: Example
+steps:
+  - type: repeat
+    count: 2
+    steps:
+      - type: ping
+        interval: 200ms
+      - type: upload
+        time: 3s
+        payload: zero
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Name != "nightly" || len(plan.Servers.IDs) != 2 || plan.Servers.Match != "Example" {
+		t.Fatalf("unexpected plan: %#v", plan)
+	}
+	repeat := plan.Steps[0]
+	if repeat.Count != 2 || len(repeat.Steps) != 2 {
+		t.Fatalf("unexpected repeat: %#v", repeat)
+	}
+	// 嵌套的步骤也经过解析
+	if repeat.Steps[0].interval != 200*time.Millisecond {
+		t.Errorf("interval = %s", repeat.Steps[0].interval)
+	}
+	if repeat.Steps[1].duration != 3*time.Second || repeat.Steps[1].payload == nil {
+		t.Errorf("unexpected upload step: %#v", repeat.Steps[1])
+	}
+}
+
+func TestValidateHTTPMode(t *testing.T) {
+	isHTTPMode = true
+	defer func() { isHTTPMode = false }()
+
+	for step, ok := range map[*planStep]bool{
+		{Type: "ping"}:                      true,
+		{Type: "download"}:                  true,
+		{Type: "dns"}:                       false,
+		{Type: "hi"}:                        false,
+		{Type: "upload", Congestion: "bbr"}: false,
+		{Type: "repeat", Count: 1, Steps: []*planStep{{Type: "hi"}}}: false,
+	} {
+		err := step.validate()
+		if (err == nil) != ok {
+			t.Errorf("%s: unexpected error: %v", step.Type, err)
+		}
+	}
+}
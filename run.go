@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// testRun 一次测速的状态, 各阶段可以与其他测速交替执行
+	testRun struct {
+		topt     *testOptions
+		prefix   string
+		tester   speedtestclient.Tester
+		withHost *speedtestclient.SpeedtestClientWithHost
+		opt      speedtestclient.UpDownloadOption
+		res      *testResult
+		err      error // 第一个错误, 之后的阶段不再执行
+	}
+
+	// stringList 可以重复的参数
+	stringList []string
+
+	// testOptions 一次测速的设置
+	testOptions struct {
+		label     string // 输出的前缀, 如网卡名
+		localAddr *net.TCPAddr
+		network   string                         // tcp, tcp4 或 tcp6
+		sockOpts  *speedtestclient.SocketOptions // 为 nil 则使用 client 的设置
+		tcpOpts   *speedtestclient.TCPOptions    // 为 nil 则使用 tcpOptions
+		mptcp     *bool                          // 为 nil 则使用 -mptcp
+	}
+
+	// testResult 一次测速的结果
+	testResult struct {
+		Label      string                           `json:"label,omitempty"`
+		LocalAddr  string                           `json:"local_addr,omitempty"`
+		RemoteAddr string                           `json:"remote_addr,omitempty"`
+		Family     string                           `json:"family,omitempty"` // 远端地址的地址族
+		Server     *speedtestclient.SpeedtestServer `json:"server"`
+		DNS        *speedtestclient.DNSRes          `json:"dns,omitempty"`
+		Hi         *speedtestclient.HIRes           `json:"hi,omitempty"`
+		Ping       *speedtestclient.PingRes         `json:"ping,omitempty"`
+		Download   *speedtestclient.UpDownloadRes   `json:"download,omitempty"`
+		Upload     *speedtestclient.UpDownloadRes   `json:"upload,omitempty"`
+		// Connections 各连接建立时各阶段用时的分布
+		Connections *speedtestclient.ConnTimingStats `json:"connections,omitempty"`
+		Error       string                           `json:"error,omitempty"`
+		// Failovers 在此之前测速失败的服务器
+		Failovers []*failedServer `json:"failovers,omitempty"`
+		// Violations 不满足的阈值
+		Violations []*violation `json:"violations,omitempty"`
+	}
+)
+
+var (
+	// out 测速结果的输出, 输出 json 时为 os.Stderr
+	out io.Writer = os.Stdout
+
+	refreshDuration  time.Duration
+	downloadDuration time.Duration
+	uploadDuration   time.Duration
+
+	// tcpOptions 下载和上传的 TCP 选项
+	tcpOptions speedtestclient.TCPOptions
+	// uploadPayload 上传的数据
+	uploadPayload *speedtestclient.Payload
+	// resolveOverrides 指定的地址
+	resolveOverrides []*speedtestclient.ResolveOverride
+)
+
+// parseDurations 解析时间相关的参数
+func parseDurations() (err error) {
+	refreshDuration, err = time.ParseDuration(strings.ToLower(refreshInterval))
+	if err != nil {
+		return fmt.Errorf("parse refresh_interval error: %s", err)
+	}
+	downloadDuration, err = time.ParseDuration(strings.ToLower(downloadTime))
+	if err != nil {
+		return fmt.Errorf("DOWNLOAD: parse down_time error: %s", err)
+	}
+	uploadDuration, err = time.ParseDuration(strings.ToLower(uploadTime))
+	if err != nil {
+		return fmt.Errorf("UPLOAD: parse up_time error: %s", err)
+	}
+	return nil
+}
+
+// parseTCPOptions 解析 TCP 选项相关的参数
+func parseTCPOptions() error {
+	tcpOptions = speedtestclient.TCPOptions{
+		Congestion: congestion,
+		RecvBuffer: recvBuffer,
+		SendBuffer: sendBuffer,
+		MSS:        mss,
+	}
+	if noDelay != "" {
+		v, err := strconv.ParseBool(noDelay)
+		if err != nil {
+			return fmt.Errorf("parse nodelay error: %s", err)
+		}
+		tcpOptions.NoDelay = &v
+	}
+	if isHTTPMode && (!tcpOptions.IsZero() || compareCC != "" || isMPTCP) {
+		return fmt.Errorf("TCP options and mptcp are not supported in http mode")
+	}
+	return nil
+}
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+// parseResolves 解析 DNS 相关的参数
+func parseResolves() error {
+	for _, s := range resolves {
+		ro, err := speedtestclient.ParseResolveOverride(s)
+		if err != nil {
+			return fmt.Errorf("parse resolve error: %s", err)
+		}
+		resolveOverrides = append(resolveOverrides, ro)
+	}
+	if isHTTPMode && (len(resolveOverrides) != 0 || dnsServer != "") {
+		return fmt.Errorf("resolve and dns_server are not supported in http mode")
+	}
+	return nil
+}
+
+func (topt *testOptions) prefix() string {
+	if topt.label == "" {
+		return ""
+	}
+	return "[" + topt.label + "] "
+}
+
+// newTester 根据参数创建 HTTP 或者 TCP 测速客户端
+func newTester(server *speedtestclient.SpeedtestServer, topt *testOptions) (tester speedtestclient.Tester, withHost *speedtestclient.SpeedtestClientWithHost, err error) {
+	if isHTTPMode {
+		withURL, err := client.WithServerURL(server)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse server url error: %s", err)
+		}
+		return withURL, nil, nil
+	}
+
+	withHost = client.WithHost(server.Host)
+
+	// set proxy
+	if proxy != "" {
+		err = withHost.SetDialProxy(proxy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("set proxy error: %s", err)
+		}
+	}
+	withHost.SetUseEnvironmentProxy(isEnvProxy)
+
+	// set resolver
+	withHost.SetResolveOverrides(resolveOverrides)
+	withHost.SetDNSServer(dnsServer)
+
+	// set local addr
+	withHost.SetLocalAddr(topt.localAddr)
+	if topt.network != "" {
+		withHost.SetNetwork(topt.network)
+	} else {
+		withHost.SetNetwork(network)
+	}
+	if topt.sockOpts != nil {
+		withHost.SetSocketOptions(topt.sockOpts)
+	}
+	if topt.mptcp != nil {
+		withHost.SetMultipathTCP(*topt.mptcp)
+	} else {
+		withHost.SetMultipathTCP(isMPTCP)
+	}
+
+	// set transport
+	t, err := speedtestclient.ParseTransport(transport)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse transport error: %s", err)
+	}
+	withHost.SetTransport(t)
+	return withHost, withHost, nil
+}
+
+// testPhases 测速的各个阶段, 依次执行
+var testPhases = []func(run *testRun) error{
+	(*testRun).dns,
+	(*testRun).hi,
+	(*testRun).ping,
+	(*testRun).download,
+	(*testRun).upload,
+}
+
+// runTest 对 server 依次执行 DNS, HI, PING, DOWNLOAD, UPLOAD
+func runTest(server *speedtestclient.SpeedtestServer, topt *testOptions) (res *testResult, err error) {
+	run := newTestRun(server, topt)
+	for phase := range testPhases {
+		if !run.step(phase) {
+			break
+		}
+	}
+	return run.finish()
+}
+
+// newTestRun 准备对 server 测速, 出错时记录在 run.err 中
+func newTestRun(server *speedtestclient.SpeedtestServer, topt *testOptions) (run *testRun) {
+	run = &testRun{
+		topt:   topt,
+		prefix: topt.prefix(),
+		res: &testResult{
+			Label:  topt.label,
+			Server: server,
+		},
+	}
+	if topt.localAddr != nil {
+		run.res.LocalAddr = (&net.IPAddr{IP: topt.localAddr.IP, Zone: topt.localAddr.Zone}).String()
+	}
+
+	run.tester, run.withHost, run.err = newTester(server, topt)
+
+	run.opt = newUpDownloadOption(topt)
+	return
+}
+
+// newUpDownloadOption 根据参数创建下载和上传的设置, 不包括时长和并行数
+func newUpDownloadOption(topt *testOptions) speedtestclient.UpDownloadOption {
+	opt := speedtestclient.UpDownloadOption{
+		CallbackInterval: refreshDuration,
+		BufferSize:       bufferSize,
+		ZeroCopy:         isZeroCopy,
+		Payload:          uploadPayload,
+		SampleDownload:   isSampleDownload,
+		TCPOptions:       tcpOptions,
+	}
+	if topt.tcpOpts != nil {
+		opt.TCPOptions = *topt.tcpOpts
+	}
+	return opt
+}
+
+// step 执行第 phase 个阶段, 之前的阶段出错时不执行, 返回是否成功
+func (run *testRun) step(phase int) bool {
+	if run.err != nil {
+		return false
+	}
+	run.err = testPhases[phase](run)
+	return run.err == nil
+}
+
+// finish 结束测速, 返回结果和第一个错误
+func (run *testRun) finish() (res *testResult, err error) {
+	if run.withHost != nil {
+		run.res.Connections = run.withHost.ConnTimings()
+		if run.res.Connections != nil {
+			fmt.Fprintf(out, "%sCONNECTIONS: %s\n", run.prefix, run.res.Connections)
+		}
+	}
+	return run.res, run.err
+}
+
+// dns 解析服务器的地址, 之后的连接都使用同一个地址
+func (run *testRun) dns() (err error) {
+	if run.withHost == nil {
+		return nil
+	}
+	res := run.res
+	res.DNS, err = run.withHost.LookupHost()
+	if err != nil {
+		return fmt.Errorf("%sDNS error: %s", run.prefix, err)
+	}
+	if res.DNS != nil {
+		fmt.Fprintf(out, "%sDNS: %s\n", run.prefix, res.DNS)
+	}
+	return nil
+}
+
+func (run *testRun) hi() error {
+	if run.withHost == nil || disableHi {
+		return nil
+	}
+	return run.doHi()
+}
+
+func (run *testRun) ping() error {
+	if disablePing {
+		return nil
+	}
+	return run.doPing(pingTimes, 1*time.Second)
+}
+
+func (run *testRun) download() error {
+	if disableDownload {
+		return nil
+	}
+	opt := run.opt
+	opt.Timeout = downloadDuration
+	opt.Parallel = downloadParallel
+	return run.doDownload(opt)
+}
+
+func (run *testRun) upload() error {
+	if disableUpload {
+		return nil
+	}
+	opt := run.opt
+	opt.Timeout = uploadDuration
+	opt.Parallel = uploadParallel
+	return run.doUpload(opt)
+}
+
+// doHi 执行 HI, 不检查 disable_hi, 测速计划中也使用
+func (run *testRun) doHi() (err error) {
+	res, prefix := run.res, run.prefix
+	res.Hi, err = run.withHost.HI()
+	if err != nil {
+		return fmt.Errorf("%sHI error: %s", prefix, err)
+	}
+	res.setConnInfo(res.Hi.ConnInfo)
+	fmt.Fprintf(out, "%sHI success, latency: %s, connection setup: %s, server %s\n", prefix, res.Hi.Latency, res.Hi.Setup.Total, res.Hi.Info)
+	return nil
+}
+
+// doPing 以 interval 为间隔 PING count 次
+func (run *testRun) doPing(count int, interval time.Duration) (err error) {
+	res, prefix := run.res, run.prefix
+	res.Ping, err = run.tester.Ping(count, interval, func(seq int, latency time.Duration) {
+		log.Printf("%s[%d] PING %s\n", prefix, seq, latency)
+	})
+	if err != nil {
+		return fmt.Errorf("%sPING error: %s", prefix, err)
+	}
+
+	res.setConnInfo(res.Ping.ConnInfo)
+	fmt.Fprintf(out, "%sPING RES: min/avg/max/median = %s/%s/%s/%s\n", prefix, res.Ping.Min, res.Ping.Average, res.Ping.Max, res.Ping.Median)
+	if res.Ping.UpstreamDelay != 0 {
+		fmt.Fprintf(out, "%sPING clock offset: %s, one-way up/down = %s/%s, asymmetry: %s\n", prefix, res.Ping.ClockOffset, res.Ping.UpstreamDelay, res.Ping.DownstreamDelay, res.Ping.Asymmetry)
+	}
+	return nil
+}
+
+// doDownload 以 opt 下载
+func (run *testRun) doDownload(opt speedtestclient.UpDownloadOption) (err error) {
+	res, prefix := run.res, run.prefix
+	res.Download, err = run.tester.Download(&opt, upDownCallback(prefix+"↓"))
+	if err != nil {
+		return fmt.Errorf("%sDOWNLOAD error: %s", prefix, err)
+	}
+
+	res.setConnInfo(res.Download.ConnInfo)
+	printRes(prefix+"DOWNLOAD", res.Download)
+	for _, warning := range res.Download.DownloadSample.Warnings() {
+		log.Printf("%sWARNING: %s\n", prefix, warning)
+	}
+	return nil
+}
+
+// doUpload 以 opt 上传
+func (run *testRun) doUpload(opt speedtestclient.UpDownloadOption) (err error) {
+	res, prefix := run.res, run.prefix
+	res.Upload, err = run.tester.Upload(&opt, upDownCallback(prefix+"↑"))
+	if err != nil {
+		return fmt.Errorf("%sUPLOAD error: %s", prefix, err)
+	}
+
+	res.setConnInfo(res.Upload.ConnInfo)
+	printRes(prefix+"UPLOAD", res.Upload)
+	return nil
+}
+
+// setConnInfo 记录连接信息
+func (res *testResult) setConnInfo(info speedtestclient.ConnInfo) {
+	if res.RemoteAddr == "" {
+		res.RemoteAddr = info.RemoteAddr
+		res.Family = info.Family
+	}
+}
+
+// printJSON 以 json 格式输出到 os.Stdout
+func printJSON(v interface{}) {
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	err := e.Encode(v)
+	if err != nil {
+		log.Fatalf("encode json error: %s\n", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// serveVersion serve 回复 HI 的版本
+	serveVersion = "2.9 (2.9.3) 2020-11-10.1948.a0bb7f8"
+	// serveChunkSize serve 下载和上传的缓冲区大小
+	serveChunkSize = 256 * 1024
+	// serveIdleTimeout 连接没有命令时的超时
+	serveIdleTimeout = 1 * time.Minute
+)
+
+func cmdServe(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	log.Printf("serving on %s\n", ln.Addr())
+	return serve(ln)
+}
+
+// serve 在 ln 上接受连接并处理, 直到 ln 关闭
+func serve(ln net.Listener) error {
+	// 所有连接共用同一份随机数据
+	chunk := make([]byte, serveChunkSize)
+	_, err := rand.Read(chunk)
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConn(conn, chunk)
+	}
+}
+
+// serveConn 处理一个连接的 HI, PING, DOWNLOAD, UPLOAD 和 QUIT
+func serveConn(conn net.Conn, chunk []byte) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(serveIdleTimeout))
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "HI":
+			_, err = io.WriteString(conn, "HELLO "+serveVersion+"\n")
+		case "PING":
+			_, err = fmt.Fprintf(conn, "PONG %d\n", time.Now().UnixNano()/int64(time.Millisecond))
+		case "DOWNLOAD":
+			err = serveDownload(conn, fields, chunk)
+		case "UPLOAD":
+			err = serveUpload(conn, br, fields, len(line))
+		case "QUIT":
+			return
+		default:
+			_, err = io.WriteString(conn, "ERROR unknown command\n")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseServeSize 解析 DOWNLOAD 或 UPLOAD 的大小
+func parseServeSize(fields []string) (size int64, err error) {
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("expect %s <size>", fields[0])
+	}
+	size, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %q", fields[1])
+	}
+	return size, nil
+}
+
+// serveDownload 发送 size 字节, 以 DOWNLOAD 开头, 以换行结尾
+func serveDownload(conn net.Conn, fields []string, chunk []byte) (err error) {
+	size, err := parseServeSize(fields)
+	if err != nil {
+		_, err = io.WriteString(conn, "ERROR "+err.Error()+"\n")
+		return
+	}
+
+	head := "DOWNLOAD "
+	if size <= int64(len(head)) {
+		_, err = io.WriteString(conn, head[:size-1]+"\n")
+		return
+	}
+	_, err = io.WriteString(conn, head)
+	if err != nil {
+		return
+	}
+	left := size - int64(len(head)) - 1
+	for left > 0 {
+		n := int64(len(chunk))
+		if left < n {
+			n = left
+		}
+		_, err = conn.Write(chunk[:n])
+		if err != nil {
+			return
+		}
+		left -= n
+	}
+	_, err = io.WriteString(conn, "\n")
+	return
+}
+
+// serveUpload 接收 size 字节, 包括命令行本身, 然后回复 OK <size> <毫秒>
+func serveUpload(conn net.Conn, br *bufio.Reader, fields []string, lineSize int) (err error) {
+	size, err := parseServeSize(fields)
+	if err != nil {
+		_, err = io.WriteString(conn, "ERROR "+err.Error()+"\n")
+		return
+	}
+
+	start := time.Now()
+	// ioutil.Discard 的 ReadFrom 只使用 8KB 的缓冲区
+	_, err = io.CopyBuffer(struct{ io.Writer }{ioutil.Discard}, io.LimitReader(br, size-int64(lineSize)), make([]byte, serveChunkSize))
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(conn, "OK %d %d\n", size, time.Since(start)/time.Millisecond)
+	return
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iikira/speedtest/speedtestclient"
+)
+
+// startServe 在随机端口启动 serve, 测试结束后关闭, 返回地址
+func startServe(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go serve(ln)
+	return ln.Addr().String()
+}
+
+// runCLI 以 args 运行命令, 不读取默认的配置文件, 不输出结果, 返回退出码
+func runCLI(t *testing.T, args ...string) int {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	oldOut := out
+	out = ioutil.Discard
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		out = oldOut
+		log.SetOutput(os.Stderr)
+		configSources = map[string]string{}
+		resolves = nil
+	})
+	configSources = map[string]string{}
+	resolves = nil
+	return runCommand(args)
+}
+
+func TestServe(t *testing.T) {
+	sch := speedtestclient.NewSpeedtestClient().WithHost(startServe(t))
+
+	hi, err := sch.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(hi.Message, serveVersion) {
+		t.Errorf("HI message = %q", hi.Message)
+	}
+
+	ping, err := sch.Ping(3, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ping.Latencies) != 3 {
+		t.Errorf("PING latencies = %v", ping.Latencies)
+	}
+
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          500 * time.Millisecond,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+	}
+	download, err := sch.Download(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if download.AverageSpeed <= 0 {
+		t.Errorf("DOWNLOAD speed = %d", download.AverageSpeed)
+	}
+	upload, err := sch.Upload(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.AverageSpeed <= 0 {
+		t.Errorf("UPLOAD speed = %d", upload.AverageSpeed)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	addr := startServe(t)
+	plan := writeTempFile(t, "plan.yaml", "servers:\n  hosts: [\""+addr+"\"]\nsteps:\n  - type: ping\n    count: 2\n    interval: 10ms\n")
+
+	cases := []struct {
+		name   string
+		args   []string
+		labels []string // 各条记录的名称
+	}{
+		{
+			name:   "single",
+			labels: []string{""},
+		},
+		{
+			name:   "compare_dscp",
+			args:   []string{"-compare_dscp", "0,EF"},
+			labels: []string{"dscp=0", "dscp=EF"},
+		},
+		{
+			name:   "plan",
+			args:   []string{"-plan", plan},
+			labels: []string{""},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			history := filepath.Join(t.TempDir(), "history.jsonl")
+			args := append([]string{"run", "-server_host", addr, "-history_file", history,
+				"-ping_times", "1", "-down_time", "300ms", "-disable_up", "-refresh_interval", "100ms"}, c.args...)
+			code := runCLI(t, args...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d", code)
+			}
+
+			records, err := readHistory(history)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(c.labels) {
+				t.Fatalf("%d records, want %d", len(records), len(c.labels))
+			}
+			for i, record := range records {
+				if record.Server != addr || record.Label != c.labels[i] || record.Error != "" {
+					t.Errorf("record %d = %+v", i, record)
+				}
+			}
+		})
+	}
+}
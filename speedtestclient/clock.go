@@ -0,0 +1,63 @@
+package speedtestclient
+
+import (
+	"sort"
+	"time"
+)
+
+type (
+	// PingSample 一次 PING 的时间戳
+	PingSample struct {
+		Sent       time.Time // 本地发送 PING 的时间
+		Received   time.Time // 本地收到 PONG 的时间
+		ServerTime time.Time // PONG 中的服务端时间, 精度为毫秒
+	}
+
+	// ClockEstimate 时钟偏差及单向延时的估算结果
+	ClockEstimate struct {
+		Offset          time.Duration // 服务端时钟减去本地时钟
+		UpstreamDelay   time.Duration // 上行单向延时的中位数
+		DownstreamDelay time.Duration // 下行单向延时的中位数
+		Asymmetry       time.Duration // 上行延时减去下行延时
+	}
+)
+
+// RTT 往返时间
+func (ps *PingSample) RTT() time.Duration {
+	return ps.Received.Sub(ps.Sent)
+}
+
+// EstimateClock 以 NTP 的方式估算时钟偏差.
+// PONG 只带一个服务端时间戳, 所以取 RTT 最小的样本,
+// 假设该样本的路径对称, 得到时钟偏差, 再用该偏差计算每个样本的单向延时.
+func EstimateClock(samples []PingSample) (est *ClockEstimate) {
+	est = &ClockEstimate{}
+	if len(samples) == 0 {
+		return
+	}
+
+	best := samples[0]
+	for _, sample := range samples[1:] {
+		if sample.RTT() < best.RTT() {
+			best = sample
+		}
+	}
+
+	// θ = T - (t0 + t3) / 2
+	est.Offset = best.ServerTime.Sub(best.Sent) - best.RTT()/2
+
+	var (
+		ups   = make([]time.Duration, 0, len(samples))
+		downs = make([]time.Duration, 0, len(samples))
+	)
+	for _, sample := range samples {
+		ups = append(ups, sample.ServerTime.Sub(sample.Sent)-est.Offset)
+		downs = append(downs, sample.Received.Sub(sample.ServerTime)+est.Offset)
+	}
+	sort.Sort(TimeDurationSlice(ups))
+	sort.Sort(TimeDurationSlice(downs))
+	est.UpstreamDelay = ups[len(ups)/2]
+	est.DownstreamDelay = downs[len(downs)/2]
+	est.Asymmetry = est.UpstreamDelay - est.DownstreamDelay
+	return
+}
@@ -0,0 +1,37 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"testing"
+	"time"
+)
+
+func TestEstimateClock(t *testing.T) {
+	var (
+		base    = time.Unix(1600000000, 0)
+		offset  = 3 * time.Second
+		samples []speedtestclient.PingSample
+	)
+	// 最小 RTT 对称 10ms/10ms, 其余样本上行多排队 20ms
+	for i, up := range []time.Duration{10, 30, 30, 30, 30} {
+		sent := base.Add(time.Duration(i) * time.Second)
+		server := sent.Add(up * time.Millisecond)
+		samples = append(samples, speedtestclient.PingSample{
+			Sent:       sent,
+			ServerTime: server.Add(offset),
+			Received:   server.Add(10 * time.Millisecond),
+		})
+	}
+
+	est := speedtestclient.EstimateClock(samples)
+	t.Logf("%#v\n", est)
+	if est.Offset != offset {
+		t.Fatalf("offset: %s, want %s\n", est.Offset, offset)
+	}
+	if est.UpstreamDelay != 30*time.Millisecond || est.DownstreamDelay != 10*time.Millisecond {
+		t.Fatalf("unexpected one-way delay: %s/%s\n", est.UpstreamDelay, est.DownstreamDelay)
+	}
+	if est.Asymmetry != 20*time.Millisecond {
+		t.Fatalf("asymmetry: %s\n", est.Asymmetry)
+	}
+}
@@ -31,6 +31,7 @@ type (
 		Lat     float64  `xml:"lat,attr"`
 		Lon     float64  `xml:"lon,attr"`
 		Host    string   `xml:"host,attr"`
+		URL     string   `xml:"url,attr"`
 	}
 
 	speedtestConfigSettings struct {
@@ -59,6 +60,7 @@ type (
 		Lat     float64
 		Lon     float64
 		Host    string
+		URL     string // upload.php 地址, 用于 HTTP 测速
 	}
 
 	SpeedtestServerList []*SpeedtestServer
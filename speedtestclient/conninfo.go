@@ -0,0 +1,68 @@
+package speedtestclient
+
+import (
+	"net"
+)
+
+type (
+	// ConnInfo 测速连接的信息
+	ConnInfo struct {
+		RemoteAddr string // 远端地址, 使用代理时为代理服务器的地址
+		Family     string // 地址族, ipv4 或 ipv6
+	}
+)
+
+// NewConnInfo 由远端地址创建 ConnInfo
+func NewConnInfo(addr net.Addr) ConnInfo {
+	if addr == nil {
+		return ConnInfo{}
+	}
+	info := ConnInfo{
+		RemoteAddr: addr.String(),
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	}
+	info.Family = AddrFamily(ip)
+	return info
+}
+
+// AddrFamily 返回 ip 的地址族, ipv4 或 ipv6
+func AddrFamily(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// SetNetwork 设置连接使用的网络, tcp, tcp4 或 tcp6, 默认 tcp,
+// 使用代理时为到代理服务器的连接使用的网络, 不影响代理服务器到测速服务器的连接
+func (sch *SpeedtestClientWithHost) SetNetwork(network string) {
+	sch.network = network
+}
+
+func (sch *SpeedtestClientWithHost) getNetwork() string {
+	if sch.network == "" {
+		return "tcp"
+	}
+	return sch.network
+}
+
+// ConnInfo 返回最近一次建立的连接的信息
+func (sch *SpeedtestClientWithHost) ConnInfo() ConnInfo {
+	info, _ := sch.connInfo.Load().(ConnInfo)
+	return info
+}
+
+func (sch *SpeedtestClientWithHost) storeConnInfo(addr net.Addr) {
+	sch.connInfo.Store(NewConnInfo(addr))
+}
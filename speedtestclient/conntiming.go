@@ -0,0 +1,125 @@
+package speedtestclient
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// ConnTiming 建立一个连接各阶段的用时
+	ConnTiming struct {
+		DNS       time.Duration // 解析地址的用时, 使用缓存或不在本地解析时为 0
+		Connect   time.Duration // 建立 TCP 连接的用时, 使用代理时包括与代理的握手
+		Handshake time.Duration // TLS 和 WebSocket 握手的用时, TCP 传输方式为 0
+		Total     time.Duration
+	}
+
+	// Distribution 用时的分布
+	Distribution struct {
+		Count   int
+		Min     time.Duration
+		Average time.Duration
+		Median  time.Duration
+		P90     time.Duration // nearest-rank 方法的第 90 百分位数
+		Max     time.Duration
+	}
+
+	// ConnTimingStats 所有连接各阶段用时的分布, 只统计经历了该阶段的连接
+	ConnTimingStats struct {
+		Connections int
+		DNS         *Distribution
+		Connect     *Distribution
+		Handshake   *Distribution
+		Total       *Distribution
+	}
+
+	// connTimingRecorder 记录连接的用时
+	connTimingRecorder struct {
+		mu      sync.Mutex
+		timings []ConnTiming
+	}
+)
+
+// NewDistribution 计算大于 0 的用时的分布, 没有则返回 nil
+func NewDistribution(durations []time.Duration) *Distribution {
+	sorted := make([]time.Duration, 0, len(durations))
+	for _, d := range durations {
+		if d > 0 {
+			sorted = append(sorted, d)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	sort.Sort(TimeDurationSlice(sorted))
+
+	dist := &Distribution{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		Median: sorted[len(sorted)/2],
+		P90:    sorted[(len(sorted)*9+9)/10-1],
+		Max:    sorted[len(sorted)-1],
+	}
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	dist.Average = sum / time.Duration(len(sorted))
+	return dist
+}
+
+func (dist *Distribution) String() string {
+	if dist == nil {
+		return "-"
+	}
+	return fmt.Sprintf("min/avg/median/p90/max = %s/%s/%s/%s/%s (%d)", dist.Min, dist.Average, dist.Median, dist.P90, dist.Max, dist.Count)
+}
+
+// add 记录连接的用时
+func (r *connTimingRecorder) add(timing ConnTiming) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timings = append(r.timings, timing)
+}
+
+// stats 返回各阶段用时的分布, 没有连接则返回 nil
+func (r *connTimingRecorder) stats() *ConnTimingStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.timings) == 0 {
+		return nil
+	}
+
+	var (
+		n         = len(r.timings)
+		dns       = make([]time.Duration, 0, n)
+		connect   = make([]time.Duration, 0, n)
+		handshake = make([]time.Duration, 0, n)
+		total     = make([]time.Duration, 0, n)
+	)
+	for _, timing := range r.timings {
+		dns = append(dns, timing.DNS)
+		connect = append(connect, timing.Connect)
+		handshake = append(handshake, timing.Handshake)
+		total = append(total, timing.Total)
+	}
+	return &ConnTimingStats{
+		Connections: n,
+		DNS:         NewDistribution(dns),
+		Connect:     NewDistribution(connect),
+		Handshake:   NewDistribution(handshake),
+		Total:       NewDistribution(total),
+	}
+}
+
+// ConnTimings 返回 sch 建立的所有连接各阶段用时的分布, 没有连接则返回 nil
+func (sch *SpeedtestClientWithHost) ConnTimings() *ConnTimingStats {
+	return sch.connTimings.stats()
+}
+
+// String 各阶段用时的分布
+func (stats *ConnTimingStats) String() string {
+	return fmt.Sprintf("%d connections\n  dns:       %s\n  connect:   %s\n  handshake: %s\n  total:     %s", stats.Connections, stats.DNS, stats.Connect, stats.Handshake, stats.Total)
+}
@@ -0,0 +1,73 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"golang.org/x/net/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDistribution(t *testing.T) {
+	dist := speedtestclient.NewDistribution([]time.Duration{0, 5, 1, 3, 2, 4, 6, 7, 8, 9, 10})
+	if dist.Count != 10 || dist.Min != 1 || dist.Max != 10 || dist.Median != 6 || dist.P90 != 9 || dist.Average != 5 {
+		t.Fatalf("unexpected distribution: %#v\n", dist)
+	}
+	if speedtestclient.NewDistribution([]time.Duration{0}) != nil {
+		t.Fatal("expected nil")
+	}
+}
+
+func TestConnTimings(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+
+	hiRes, err := withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hiRes.Setup.Connect == 0 || hiRes.Setup.Total < hiRes.Setup.Connect {
+		t.Fatalf("unexpected setup timing: %#v\n", hiRes.Setup)
+	}
+	_, err = withHost.Download(&speedtestclient.UpDownloadOption{
+		Timeout:          200 * time.Millisecond,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := withHost.ConnTimings()
+	t.Log(stats)
+	if stats.Connections < 3 || stats.Connect.Count != stats.Connections || stats.Handshake != nil {
+		t.Fatalf("unexpected conn timings: %#v\n", stats)
+	}
+}
+
+func TestWebSocketHandshakeTiming(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var msg string
+		for websocket.Message.Receive(ws, &msg) == nil {
+			if msg == "HI" {
+				websocket.Message.Send(ws, "HELLO 2.9 (2.9.3) 2020-11-10.1948.a0bb7f8\n")
+			}
+		}
+	}))
+	defer server.Close()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(strings.TrimPrefix(server.URL, "http://"))
+	withHost.SetTransport(speedtestclient.TransportWebSocket)
+
+	// WebSocket 的升级计入 Handshake
+	hiRes, err := withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hiRes.Setup.Handshake == 0 || hiRes.Setup.Total < hiRes.Setup.Connect+hiRes.Setup.Handshake {
+		t.Fatalf("unexpected setup timing: %#v\n", hiRes.Setup)
+	}
+}
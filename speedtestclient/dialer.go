@@ -0,0 +1,46 @@
+package speedtestclient
+
+import (
+	"net"
+)
+
+type (
+	// Dialer 建立到测速服务器的连接, 可以返回任意的 net.Conn,
+	// 如 TLS 连接, 内存中的 net.Pipe, 限速或统计的包装等
+	Dialer interface {
+		Dial(network, addr string) (net.Conn, error)
+	}
+
+	// DialerFunc 将函数转换为 Dialer
+	DialerFunc func(network, addr string) (net.Conn, error)
+)
+
+// Dial 调用 f(network, addr)
+func (f DialerFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(network, addr)
+}
+
+// SetDialer 设置建立连接使用的 Dialer, 代理和传输方式仍在其之上生效,
+// 为 nil 则使用默认的 net.Dialer
+func (sch *SpeedtestClientWithHost) SetDialer(dialer Dialer) {
+	sch.dialer = dialer
+}
+
+// SetLocalAddr 设置测速连接的本地地址, 不影响获取配置的连接
+func (sch *SpeedtestClientWithHost) SetLocalAddr(localAddr *net.TCPAddr) {
+	sch.localAddr = localAddr
+}
+
+// baseDialer 返回底层的 Dialer, 使用自定义的 Dialer 时,
+// tcpOpts 中仅有连接建立之后可以设置的选项生效
+func (sch *SpeedtestClientWithHost) baseDialer(tcpOpts *TCPOptions) Dialer {
+	if sch.dialer != nil {
+		return sch.dialer
+	}
+	dialer := &net.Dialer{
+		LocalAddr: sch.localAddr,
+		Control:   tcpOpts.control(sch.sockOpts.control()),
+	}
+	dialer.SetMultipathTCP(sch.mptcp)
+	return dialer
+}
@@ -0,0 +1,57 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"net"
+	"testing"
+	"time"
+)
+
+func newPipeHost() *speedtestclient.SpeedtestClientWithHost {
+	withHost := speedtestclient.NewSpeedtestClient().WithHost("pipe:8080")
+	withHost.SetDialer(speedtestclient.DialerFunc(func(network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveFake(server)
+		return client, nil
+	}))
+	return withHost
+}
+
+func TestPipeDialer(t *testing.T) {
+	withHost := newPipeHost()
+
+	hiRes, err := withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%#v\n", hiRes.Info)
+
+	pingRes, err := withHost.Ping(3, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pingRes.Latencies) != 3 {
+		t.Fatalf("unexpected ping result: %#v\n", pingRes)
+	}
+
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          300 * time.Millisecond,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+	}
+	downRes, err := withHost.Download(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downRes.AverageSpeed == 0 {
+		t.Fatalf("no data downloaded: %#v\n", downRes)
+	}
+
+	upRes, err := withHost.Upload(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upRes.AverageSpeed == 0 {
+		t.Fatalf("no data uploaded: %#v\n", upRes)
+	}
+}
@@ -0,0 +1,237 @@
+package speedtestclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DNSTimeout 解析测速服务器地址的超时时间
+	DNSTimeout = 10 * time.Second
+)
+
+type (
+	// ResolveOverride 指定域名和端口使用的地址, 类似 curl 的 --resolve
+	ResolveOverride struct {
+		Host string
+		Port string
+		IP   net.IP
+	}
+
+	// DNSRes 解析测速服务器地址的结果
+	DNSRes struct {
+		Host      string        // 域名
+		Addrs     []string      // 解析得到的地址, 连接时依次尝试
+		Addr      string        // 首选的地址, ip:port
+		DNSServer string        // 使用的 DNS 服务器, 为空则为系统的解析
+		Override  bool          // 是否为指定的地址
+		Latency   time.Duration // 解析用时
+	}
+)
+
+// ParseResolveOverride 解析 host:port:ip, IPv6 地址可以带有方括号
+func ParseResolveOverride(s string) (ro *ResolveOverride, err error) {
+	fields := strings.SplitN(s, ":", 3)
+	if len(fields) != 3 || fields[0] == "" || fields[1] == "" {
+		return nil, fmt.Errorf("invalid resolve %q, expect host:port:ip", s)
+	}
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(fields[2], "["), "]"))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid resolve %q, invalid ip", s)
+	}
+	return &ResolveOverride{
+		Host: strings.ToLower(fields[0]),
+		Port: fields[1],
+		IP:   ip,
+	}, nil
+}
+
+func (ro *ResolveOverride) String() string {
+	return ro.Host + ":" + ro.Port + ":" + ro.IP.String()
+}
+
+// SetResolveOverrides 设置指定的地址, 即使使用代理也会生效
+func (sch *SpeedtestClientWithHost) SetResolveOverrides(overrides []*ResolveOverride) {
+	sch.resolveOverrides = overrides
+}
+
+// SetDNSServer 设置解析测速服务器地址使用的 DNS 服务器, 如 8.8.8.8 或 [2001:4860:4860::8888]:53,
+// 为空则使用系统的解析
+func (sch *SpeedtestClientWithHost) SetDNSServer(server string) {
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"), "53")
+		}
+	}
+	sch.dnsServer = server
+}
+
+// resolver 返回使用的解析器
+func (sch *SpeedtestClientWithHost) resolver() *net.Resolver {
+	if sch.dnsServer == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			// 与测速连接使用相同的本地地址, 套接字选项和地址族, 使查询从同一个网卡发出
+			d := net.Dialer{
+				Control: sch.sockOpts.control(),
+			}
+			if la := sch.localAddr; la != nil {
+				if strings.HasPrefix(network, "udp") {
+					d.LocalAddr = &net.UDPAddr{IP: la.IP, Zone: la.Zone}
+				} else {
+					d.LocalAddr = &net.TCPAddr{IP: la.IP, Zone: la.Zone}
+				}
+			}
+			switch sch.getNetwork() {
+			case "tcp4":
+				network = strings.TrimRight(network, "46") + "4"
+			case "tcp6":
+				network = strings.TrimRight(network, "46") + "6"
+			}
+			return d.DialContext(ctx, network, sch.dnsServer)
+		},
+	}
+}
+
+// matchNetwork ip 是否可以用于 network, tcp4 只能使用 IPv4 地址, tcp6 只能使用 IPv6 地址
+func matchNetwork(network string, ip net.IP) bool {
+	switch network {
+	case "tcp4":
+		return ip.To4() != nil
+	case "tcp6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// override 返回 host:port 指定的地址, 没有则返回 nil
+func (sch *SpeedtestClientWithHost) override(host, port string) net.IP {
+	for _, ro := range sch.resolveOverrides {
+		if ro.Host == strings.ToLower(host) && ro.Port == port {
+			return ro.IP
+		}
+	}
+	return nil
+}
+
+// Resolve 解析 sch.Host, 结果会被缓存, 之后的连接都使用同一个地址
+func (sch *SpeedtestClientWithHost) Resolve() (res *DNSRes, err error) {
+	res, _, err = sch.resolve()
+	return
+}
+
+// resolve 解析 sch.Host, cached 表示是否使用了缓存
+func (sch *SpeedtestClientWithHost) resolve() (res *DNSRes, cached bool, err error) {
+	sch.dnsMu.Lock()
+	defer sch.dnsMu.Unlock()
+	if sch.dnsRes != nil {
+		return sch.dnsRes, true, nil
+	}
+
+	host, port, err := net.SplitHostPort(sch.Host)
+	if err != nil {
+		return
+	}
+	res = &DNSRes{
+		Host: host,
+	}
+
+	if ip := sch.override(host, port); ip != nil {
+		if !matchNetwork(sch.getNetwork(), ip) {
+			return nil, false, fmt.Errorf("resolve %s:%s:%s does not match network %s", host, port, ip, sch.getNetwork())
+		}
+		res.Override = true
+		res.Addrs = []string{ip.String()}
+	} else if ip := net.ParseIP(host); ip != nil {
+		if !matchNetwork(sch.getNetwork(), ip) {
+			return nil, false, fmt.Errorf("address %s does not match network %s", host, sch.getNetwork())
+		}
+		res.Addrs = []string{ip.String()}
+	} else {
+		res.DNSServer = sch.dnsServer
+		ctx, cancel := context.WithTimeout(context.Background(), DNSTimeout)
+		defer cancel()
+
+		network := "ip"
+		switch sch.getNetwork() {
+		case "tcp4":
+			network = "ip4"
+		case "tcp6":
+			network = "ip6"
+		}
+
+		start := time.Now()
+		ips, err := sch.resolver().LookupIP(ctx, network, host)
+		res.Latency = time.Since(start)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, ip := range ips {
+			res.Addrs = append(res.Addrs, ip.String())
+		}
+		if len(res.Addrs) == 0 {
+			return nil, false, &net.DNSError{Err: "no suitable address", Name: host}
+		}
+	}
+
+	res.Addr = net.JoinHostPort(res.Addrs[0], port)
+	sch.dnsRes = res
+	return res, false, nil
+}
+
+// localResolve 是否在本地解析, 使用代理或自定义的 Dialer 时,
+// 除非指定了地址, 否则由代理或 Dialer 解析
+func (sch *SpeedtestClientWithHost) localResolve(useProxy bool) bool {
+	if !useProxy && sch.dialer == nil {
+		return true
+	}
+	host, port, err := net.SplitHostPort(sch.Host)
+	return err == nil && sch.override(host, port) != nil
+}
+
+// LookupHost 在本地解析 sch.Host 并缓存, 由代理或自定义的 Dialer 解析时返回 nil
+func (sch *SpeedtestClientWithHost) LookupHost() (res *DNSRes, err error) {
+	proxyURL, err := sch.getProxyURL()
+	if err != nil {
+		return
+	}
+	if !sch.localResolve(proxyURL != nil) {
+		return nil, nil
+	}
+	return sch.Resolve()
+}
+
+// dialAddrs 返回连接依次尝试的地址, 以及本次解析的用时, 使用缓存时为 0
+func (sch *SpeedtestClientWithHost) dialAddrs(useProxy bool) (addrs []string, dnsTime time.Duration, err error) {
+	if !sch.localResolve(useProxy) {
+		return []string{sch.Host}, 0, nil
+	}
+	res, cached, err := sch.resolve()
+	if err != nil {
+		return
+	}
+	if !cached {
+		dnsTime = res.Latency
+	}
+	_, port, _ := net.SplitHostPort(res.Addr)
+	for _, ip := range res.Addrs {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs, dnsTime, nil
+}
+
+func (res *DNSRes) String() string {
+	source := "system resolver"
+	if res.Override {
+		source = "override"
+	} else if res.DNSServer != "" {
+		source = res.DNSServer
+	}
+	return fmt.Sprintf("%s -> %s in %s (%s), addrs: %s", res.Host, res.Addr, res.Latency, source, strings.Join(res.Addrs, ", "))
+}
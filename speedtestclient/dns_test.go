@@ -0,0 +1,201 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"testing"
+)
+
+func TestParseResolveOverride(t *testing.T) {
+	for s, expected := range map[string]string{
+		"example.com:8080:127.0.0.1": "example.com:8080:127.0.0.1",
+		"Example.com:8080:[::1]":     "example.com:8080:::1",
+		"example.com:8080:::1":       "example.com:8080:::1",
+	} {
+		ro, err := speedtestclient.ParseResolveOverride(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ro.String() != expected {
+			t.Fatalf("%s: expected %s, got %s\n", s, expected, ro)
+		}
+	}
+	for _, s := range []string{"example.com:8080", "example.com:8080:host", ":8080:127.0.0.1"} {
+		_, err := speedtestclient.ParseResolveOverride(s)
+		if err == nil {
+			t.Fatalf("%s: expected error\n", s)
+		}
+	}
+}
+
+func TestResolveOverride(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+	_, port, _ := net.SplitHostPort(addr)
+
+	ro, err := speedtestclient.ParseResolveOverride("speedtest.invalid:" + port + ":127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withHost := speedtestclient.NewSpeedtestClient().WithHost("speedtest.invalid:" + port)
+	withHost.SetResolveOverrides([]*speedtestclient.ResolveOverride{ro})
+
+	_, err = withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsRes, err := withHost.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dnsRes.Override || dnsRes.Addr != addr {
+		t.Fatalf("unexpected dns result: %#v\n", dnsRes)
+	}
+}
+
+// serveDNS 在本地运行只回答 A 记录 127.0.0.1 的 DNS 服务器, 返回服务器的地址
+func serveDNS(t *testing.T) (addr string, closeFn func()) {
+	return serveDNSClients(t, nil)
+}
+
+// serveDNSClients 同 serveDNS, clients 不为 nil 时接收每个查询的来源地址
+func serveDNSClients(t *testing.T, clients chan<- net.Addr) (addr string, closeFn func()) {
+	return serveDNSAnswers(t, clients, [4]byte{127, 0, 0, 1})
+}
+
+// serveDNSAnswers 同 serveDNSClients, 按顺序回答 A 记录 answers
+func serveDNSAnswers(t *testing.T, clients chan<- net.Addr, answers ...[4]byte) (addr string, closeFn func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, raddr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if req.Unpack(buf[:n]) != nil || len(req.Questions) == 0 {
+				continue
+			}
+			if clients != nil {
+				select {
+				case clients <- raddr:
+				default:
+				}
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+				Questions: req.Questions,
+			}
+			if q.Type == dnsmessage.TypeA {
+				for _, a := range answers {
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &dnsmessage.AResource{A: a},
+					})
+				}
+			}
+			packed, err := resp.Pack()
+			if err == nil {
+				pc.WriteTo(packed, raddr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String(), func() { pc.Close() }
+}
+
+func TestDNSServer(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+	_, port, _ := net.SplitHostPort(addr)
+
+	dnsAddr, closeDNS := serveDNS(t)
+	defer closeDNS()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost("speedtest.example:" + port)
+	withHost.SetNetwork("tcp4")
+	withHost.SetDNSServer(dnsAddr)
+
+	dnsRes, err := withHost.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(dnsRes)
+	if dnsRes.Addr != addr || dnsRes.DNSServer != dnsAddr {
+		t.Fatalf("unexpected dns result: %#v\n", dnsRes)
+	}
+	_, err = withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDNSServerFallback(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+	_, port, _ := net.SplitHostPort(addr)
+
+	// 127.0.0.2 上没有监听, 应当继续尝试 127.0.0.1
+	dnsAddr, closeDNS := serveDNSAnswers(t, nil, [4]byte{127, 0, 0, 2}, [4]byte{127, 0, 0, 1})
+	defer closeDNS()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost("speedtest.example:" + port)
+	withHost.SetNetwork("tcp4")
+	withHost.SetDNSServer(dnsAddr)
+
+	_, err := withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsRes, err := withHost.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dnsRes.Addrs) != 2 || dnsRes.Addr != net.JoinHostPort("127.0.0.2", port) {
+		t.Fatalf("unexpected dns result: %#v\n", dnsRes)
+	}
+	if remote := withHost.ConnInfo().RemoteAddr; remote != addr {
+		t.Fatalf("RemoteAddr = %s, expected %s\n", remote, addr)
+	}
+}
+
+func TestDNSServerLocalAddr(t *testing.T) {
+	clients := make(chan net.Addr, 10)
+	dnsAddr, closeDNS := serveDNSClients(t, clients)
+	defer closeDNS()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost("speedtest.example:8080")
+	withHost.SetNetwork("tcp4")
+	withHost.SetDNSServer(dnsAddr)
+	withHost.SetLocalAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)})
+
+	_, err := withHost.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := (<-clients).(*net.UDPAddr)
+	if !client.IP.Equal(net.IPv4(127, 0, 0, 2)) {
+		t.Fatalf("DNS query from %s, expected the local addr 127.0.0.2\n", client)
+	}
+}
+
+func TestResolveOverrideFamily(t *testing.T) {
+	ro, err := speedtestclient.ParseResolveOverride("speedtest.invalid:8080:[::1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for network, ok := range map[string]bool{"tcp": true, "tcp4": false, "tcp6": true} {
+		withHost := speedtestclient.NewSpeedtestClient().WithHost("speedtest.invalid:8080")
+		withHost.SetResolveOverrides([]*speedtestclient.ResolveOverride{ro})
+		withHost.SetNetwork(network)
+		_, err = withHost.Resolve()
+		if (err == nil) != ok {
+			t.Fatalf("%s: unexpected error: %v\n", network, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package speedtestclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	// downloadSampleSize 采样下载数据开头的数据量
+	downloadSampleSize = 1024 * 1024
+	// compressibleRatio 压缩比低于该值则认为数据可压缩
+	compressibleRatio = 0.9
+)
+
+type (
+	// DownloadSample 对下载数据开头 downloadSampleSize 字节的检查结果,
+	// 只检查可压缩性和是否像 HTTP 响应或网页, 不校验完整的数据.
+	// 下载的数据可压缩时, 经过压缩的代理或 VPN 测得的速度会偏高
+	DownloadSample struct {
+		SampleSize   int     // 采样的数据量
+		Entropy      float64 // 每字节的信息熵, 0 到 8
+		Compression  float64 // 使用 flate 压缩后与压缩前的大小之比
+		Compressible bool    // 是否可压缩
+		Rewritten    bool    // 是否像是被中间设备替换的 HTTP 响应或网页
+	}
+
+	// downloadSampler 采样下载数据的开头
+	downloadSampler struct {
+		mu     sync.Mutex
+		sample []byte
+	}
+)
+
+func newDownloadSampler() *downloadSampler {
+	return &downloadSampler{
+		sample: make([]byte, 0, downloadSampleSize),
+	}
+}
+
+// full 是否已经采样足够的数据
+func (c *downloadSampler) full() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sample) >= downloadSampleSize
+}
+
+// write 采样数据, 采样足够后忽略, 返回是否已经采样足够的数据
+func (c *downloadSampler) write(b []byte) (full bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if left := downloadSampleSize - len(c.sample); len(b) > left {
+		b = b[:left]
+	}
+	c.sample = append(c.sample, b...)
+	return len(c.sample) >= downloadSampleSize
+}
+
+// result 返回检查结果, 没有采样到数据则返回 nil
+func (c *downloadSampler) result() *DownloadSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sample) == 0 {
+		return nil
+	}
+
+	res := &DownloadSample{
+		SampleSize: len(c.sample),
+		Entropy:    entropy(c.sample),
+	}
+
+	compressed := bytes.Buffer{}
+	w, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+	w.Write(c.sample)
+	w.Close()
+	res.Compression = float64(compressed.Len()) / float64(len(c.sample))
+	res.Compressible = res.Compression < compressibleRatio
+
+	head := c.sample
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	res.Rewritten = bytes.HasPrefix(head, []byte("HTTP/1.")) || bytes.Contains(bytes.ToLower(head), []byte("<html"))
+	return res
+}
+
+// entropy 每字节的香农熵
+func entropy(b []byte) (e float64) {
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(b))
+		e -= p * math.Log2(p)
+	}
+	return
+}
+
+// Warnings 返回采样发现的问题
+func (dc *DownloadSample) Warnings() (warnings []string) {
+	if dc == nil {
+		return nil
+	}
+	if dc.Compressible {
+		warnings = append(warnings, fmt.Sprintf("download data is compressible (ratio %.2f, entropy %.2f bits/byte), compressing proxies or VPNs may inflate the result", dc.Compression, dc.Entropy))
+	}
+	if dc.Rewritten {
+		warnings = append(warnings, "download data looks like an HTTP response or HTML page, a middlebox may rewrite the traffic")
+	}
+	return
+}
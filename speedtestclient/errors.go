@@ -9,6 +9,14 @@ var (
 	ErrHiResponse     = errors.New("unexpected HI response")
 	ErrPingResponse   = errors.New("unexpected PING response")
 	ErrNotSocks5Proxy = errors.New("not socks5 proxy")
+
+	ErrSocketOptionUnsupported = errors.New("socket option is not supported on this platform")
+	ErrTCPInfoUnsupported      = errors.New("TCP_INFO is not supported")
+	ErrInvalidPayload          = errors.New("invalid payload, expect random, zero, pattern:<content> or file:<path>")
+	ErrNoHosts                 = errors.New("no hosts to test")
+	ErrParallelLessThanHosts   = errors.New("parallel is less than the number of hosts")
+	ErrUnsupportedProxy        = errors.New("unsupported proxy scheme, expect http, https, socks5, socks5h, socks4 or socks4a")
+	ErrNotSyscallConn          = errors.New("connection does not expose the underlying socket")
 )
 
 func IsTimeout(err error) bool {
@@ -0,0 +1,70 @@
+package speedtestclient_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveFake 模拟 speedtest 服务端, 处理 HI, PING, DOWNLOAD, UPLOAD
+func serveFake(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "HI":
+			io.WriteString(conn, "HELLO 2.9 (2.9.3) 2020-11-10.1948.a0bb7f8\n")
+		case "PING":
+			fmt.Fprintf(conn, "PONG %d\n", time.Now().UnixNano()/1e6)
+		case "DOWNLOAD":
+			buf := make([]byte, 32*1024)
+			for {
+				if _, err = conn.Write(buf); err != nil {
+					return
+				}
+			}
+		case "UPLOAD":
+			size, _ := strconv.ParseInt(fields[1], 10, 64)
+			// ioutil.Discard 的 ReadFrom 只使用 8KB 的缓冲区
+			io.CopyBuffer(struct{ io.Writer }{ioutil.Discard}, io.LimitReader(br, size), make([]byte, 256*1024))
+			return
+		}
+	}
+}
+
+// listenFake 在本地监听, 返回模拟服务端的地址
+func listenFake(t testing.TB) (addr string, closeFn func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveFakeListener(ln)
+}
+
+// serveFakeListener 在 ln 上运行模拟服务端
+func serveFakeListener(ln net.Listener) (addr string, closeFn func()) {
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFake(conn)
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
@@ -0,0 +1,285 @@
+package speedtestclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iikira/iikira-go-utils/requester/rio/speeds"
+	"github.com/iikira/iikira-go-utils/utils/converter"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// HTTPUploadSize HTTP 测速每次 POST 的数据量
+	HTTPUploadSize = 4 * converter.MB
+	// HTTPUploadPath 默认的 upload.php 路径
+	HTTPUploadPath = "/speedtest/upload.php"
+)
+
+var (
+	// HTTPDownloadSizes HTTP 测速下载的图片尺寸, random<size>x<size>.jpg
+	HTTPDownloadSizes = []int{350, 500, 750, 1000, 1500, 2000, 2500, 3000, 3500, 4000}
+)
+
+type (
+	// SpeedtestClientWithURL 使用 HTTP 测速,
+	// 下载 random<size>x<size>.jpg, 上传到 upload.php
+	SpeedtestClientWithURL struct {
+		SpeedtestClient
+		URL *url.URL // upload.php 地址
+	}
+
+	// uploadBodyReader 重复 buf 直到 size, 并统计已读取的数据量
+	uploadBodyReader struct {
+		ctx       context.Context
+		payload   *payload
+		off       int
+		left      int64
+		statistic *Statistic
+		speedStat *speeds.Speeds
+	}
+)
+
+// WithURL 根据服务器的 url 创建 HTTP 测速客户端,
+// serverURL 可以是 upload.php 地址, 或者只包含 host
+func (sc *SpeedtestClient) WithURL(serverURL string) (scu *SpeedtestClientWithURL, err error) {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "http://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = HTTPUploadPath
+	}
+
+	sc.lazyInit() // 复制前初始化, 使测速时只读取 transport
+	return &SpeedtestClientWithURL{
+		SpeedtestClient: *sc,
+		URL:             u,
+	}, nil
+}
+
+// WithServerURL 根据服务器信息创建 HTTP 测速客户端, 未提供 url 时由 host 推导
+func (sc *SpeedtestClient) WithServerURL(server *SpeedtestServer) (scu *SpeedtestClientWithURL, err error) {
+	if server.URL != "" {
+		return sc.WithURL(server.URL)
+	}
+	return sc.WithURL(server.Host)
+}
+
+// httpClient 返回 HTTP 测速使用的 http.Client, 复用代理等设置, 不设超时
+func (sc *SpeedtestClient) httpClient() *http.Client {
+	return &http.Client{
+		Transport: sc.httpTest,
+		Jar:       sc.hc.Jar,
+	}
+}
+
+// resolve 返回与 upload.php 同目录下的文件地址
+func (scu *SpeedtestClientWithURL) resolve(name string) string {
+	u := *scu.URL
+	u.Path = path.Join(path.Dir(u.Path), name)
+	u.RawQuery = fmt.Sprintf("x=%d", time.Now().UnixNano()) // 避免缓存
+	return u.String()
+}
+
+func (scu *SpeedtestClientWithURL) newRequest(ctx context.Context, method, urlStr string, body io.Reader) (req *http.Request, err error) {
+	req, err = http.NewRequest(method, urlStr, body)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept-Encoding", "identity") // 禁用压缩
+	req.Header.Set("Cache-Control", "no-cache")
+	return
+}
+
+// Ping 请求 latency.txt 测量延时
+func (scu *SpeedtestClientWithURL) Ping(times int, sleep time.Duration, callback PingCallback) (res *PingRes, err error) {
+	if times < 1 {
+		res = NewPingRes(nil)
+		return
+	}
+
+	var (
+		hc        = scu.httpClient()
+		latencies = make([]time.Duration, 0, times)
+	)
+	for i := 0; i < times; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), PingTimeout)
+		req, err := scu.newRequest(ctx, "GET", scu.resolve("latency.txt"), nil)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		nowTime := time.Now()
+		resp, err := hc.Do(req)
+		if err != nil {
+			timeout := errors.Is(err, context.DeadlineExceeded) // 在 cancel 之前判断
+			cancel()
+			if timeout {
+				latencies = append(latencies, -1)
+				continue
+			}
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		// 计算延时
+		latency := time.Since(nowTime)
+		if !strings.HasPrefix(string(body), "test=test") {
+			return nil, ErrPingResponse
+		}
+
+		latencies = append(latencies, latency)
+		if callback != nil {
+			callback(i, latency)
+		}
+		time.Sleep(sleep)
+	}
+
+	res = NewPingRes(latencies)
+	return
+}
+
+func (scu *SpeedtestClientWithURL) Download(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
+	var (
+		hc      = scu.httpClient()
+		seq     int32
+		sampler = opt.downloadSampler()
+	)
+	res, err = upDownload(opt, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
+		size := HTTPDownloadSizes[int(atomic.AddInt32(&seq, 1)-1)%len(HTTPDownloadSizes)]
+		req, err := scu.newRequest(ctx, "GET", scu.resolve(fmt.Sprintf("random%dx%d.jpg", size, size)), nil)
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		resp, err := hc.Do(req)
+		if err != nil {
+			errChan <- ignoreCanceled(ctx, err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errChan <- fmt.Errorf("DOWNLOAD: unexpected http status: %s", resp.Status)
+			return
+		}
+
+		// 并行的连接共用 commonBuf, 采样时使用单独的缓冲区, 采样足够后换回 commonBuf
+		buf, s := commonBuf, sampler
+		if s != nil && !s.full() {
+			buf = make([]byte, len(commonBuf))
+		} else {
+			s = nil
+		}
+
+		var n int
+		for {
+			n, err = resp.Body.Read(buf)
+			if s != nil && n > 0 && s.write(buf[:n]) {
+				buf, s = commonBuf, nil
+			}
+			speedStat.Add(int64(n))
+			statistic.AddTransferSize(int64(n)) // 增加
+			if err != nil {
+				break
+			}
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		errChan <- ignoreCanceled(ctx, err)
+	})
+	if res != nil && sampler != nil {
+		res.DownloadSample = sampler.result()
+	}
+	return
+}
+
+func (scu *SpeedtestClientWithURL) Upload(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
+	hc := scu.httpClient()
+	p, err := opt.newPayload()
+	if err != nil {
+		return
+	}
+	defer p.Close()
+
+	res, err = upDownload(opt, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
+		body := &uploadBodyReader{
+			ctx:       ctx,
+			payload:   p,
+			left:      HTTPUploadSize,
+			statistic: statistic,
+			speedStat: speedStat,
+		}
+		req, err := scu.newRequest(ctx, "POST", scu.resolve(path.Base(scu.URL.Path)), body)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		req.ContentLength = HTTPUploadSize
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := hc.Do(req)
+		if err != nil {
+			errChan <- ignoreCanceled(ctx, err)
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errChan <- fmt.Errorf("UPLOAD: unexpected http status: %s", resp.Status)
+			return
+		}
+		errChan <- nil
+	})
+	if res != nil {
+		res.Payload = opt.payload().String()
+	}
+	return
+}
+
+func (ubr *uploadBodyReader) Read(p []byte) (n int, err error) {
+	if ubr.left <= 0 {
+		return 0, io.EOF
+	}
+	if ubr.ctx.Err() != nil {
+		return 0, ubr.ctx.Err()
+	}
+
+	if int64(len(p)) > ubr.left {
+		p = p[:ubr.left]
+	}
+	b := ubr.payload.next(&ubr.off)
+	n = copy(p, b)
+	ubr.off -= len(b) - n // 未复制的数据下次再发送
+	ubr.left -= int64(n)
+	ubr.speedStat.Add(int64(n))
+	ubr.statistic.AddTransferSize(int64(n)) // 增加
+	return n, nil
+}
+
+// ignoreCanceled 测速到达截止时间而产生的错误, 不作为错误
+func ignoreCanceled(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
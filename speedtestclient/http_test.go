@@ -0,0 +1,75 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHTTPMode(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/speedtest/latency.txt", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "test=test\n")
+	})
+	mux.HandleFunc("/speedtest/upload.php", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		io.WriteString(w, "size="+strconv.FormatInt(n, 10))
+	})
+	mux.HandleFunc("/speedtest/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<20))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	withURL, err := speedtestclient.NewSpeedtestClient().WithURL(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pingRes, err := withURL.Ping(2, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%#v\n", pingRes)
+
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          500 * time.Millisecond,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+	}
+	downRes, err := withURL.Download(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downRes.AverageSpeed == 0 {
+		t.Fatalf("no data downloaded: %#v\n", downRes)
+	}
+
+	upRes, err := withURL.Upload(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upRes.AverageSpeed == 0 {
+		t.Fatalf("no data uploaded: %#v\n", upRes)
+	}
+}
+
+func TestHTTPPingError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	withURL, err := speedtestclient.NewSpeedtestClient().WithURL(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 连接被拒绝不是超时, 应当返回错误
+	res, err := withURL.Ping(2, 0, nil)
+	if err == nil {
+		t.Fatalf("expected error, got %#v", res)
+	}
+}
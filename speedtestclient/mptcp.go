@@ -0,0 +1,72 @@
+package speedtestclient
+
+import (
+	"net"
+	"sync"
+)
+
+type (
+	// MPTCPStats 下载或上传期间, 各连接的 MPTCP 协商结果
+	MPTCPStats struct {
+		Streams    []bool // 每个连接是否使用了 MPTCP
+		Negotiated int    // 使用了 MPTCP 的连接数
+	}
+
+	// mptcpRecorder 记录连接的 MPTCP 协商结果
+	mptcpRecorder struct {
+		mu      sync.Mutex
+		streams []bool
+	}
+)
+
+// SetMultipathTCP 使用 MPTCP 建立测速连接, 仅 Linux,
+// 内核或服务端不支持时使用 TCP, 使用自定义的 Dialer 时无效
+func (sch *SpeedtestClientWithHost) SetMultipathTCP(use bool) {
+	sch.mptcp = use
+}
+
+// IsMultipathTCP 判断连接是否使用了 MPTCP
+func IsMultipathTCP(conn net.Conn) bool {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+	use, err := tcpConn.MultipathTCP()
+	return err == nil && use
+}
+
+// newMPTCPRecorder 开启 MPTCP 时返回 mptcpRecorder, 否则返回 nil
+func (sch *SpeedtestClientWithHost) newMPTCPRecorder() *mptcpRecorder {
+	if !sch.mptcp {
+		return nil
+	}
+	return &mptcpRecorder{}
+}
+
+// add 记录连接
+func (r *mptcpRecorder) add(conn *hostConn) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.streams = append(r.streams, IsMultipathTCP(conn.raw))
+}
+
+// stats 返回记录的结果, r 为 nil 则返回 nil
+func (r *mptcpRecorder) stats() *MPTCPStats {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := &MPTCPStats{
+		Streams: append([]bool(nil), r.streams...),
+	}
+	for _, use := range r.streams {
+		if use {
+			stats.Negotiated++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,73 @@
+package speedtestclient_test
+
+import (
+	"context"
+	"github.com/iikira/speedtest/speedtestclient"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipathTCP(t *testing.T) {
+	lc := net.ListenConfig{}
+	lc.SetMultipathTCP(true)
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, closeFn := serveFakeListener(ln)
+	defer closeFn()
+
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          200 * time.Millisecond,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+	}
+	for _, use := range []bool{false, true} {
+		withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+		withHost.SetMultipathTCP(use)
+
+		res, err := withHost.Download(opt, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !use {
+			if res.MPTCP != nil {
+				t.Fatalf("unexpected mptcp stats: %#v\n", res.MPTCP)
+			}
+			continue
+		}
+		if res.MPTCP == nil || len(res.MPTCP.Streams) == 0 {
+			t.Fatalf("unexpected mptcp stats: %#v\n", res.MPTCP)
+		}
+		t.Logf("mptcp: %#v\n", res.MPTCP)
+
+		// 内核开启 MPTCP 时应当协商成功, 否则使用 TCP
+		enabled, _ := ioutil.ReadFile("/proc/sys/net/mptcp/enabled")
+		if strings.TrimSpace(string(enabled)) == "1" && res.MPTCP.Negotiated == 0 {
+			t.Fatalf("mptcp not negotiated: %#v\n", res.MPTCP)
+		}
+	}
+}
+
+func TestMultipathTCPFallback(t *testing.T) {
+	// 服务端不支持 MPTCP 时使用 TCP
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+	withHost.SetMultipathTCP(true)
+
+	res, err := withHost.Download(&speedtestclient.UpDownloadOption{
+		Timeout:          200 * time.Millisecond,
+		CallbackInterval: 100 * time.Millisecond,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.AverageSpeed == 0 || res.MPTCP == nil || res.MPTCP.Negotiated != 0 {
+		t.Fatalf("unexpected result: %#v, %#v\n", res, res.MPTCP)
+	}
+}
@@ -0,0 +1,150 @@
+package speedtestclient
+
+import (
+	"context"
+	"github.com/iikira/iikira-go-utils/requester/rio/speeds"
+	"sync"
+	"time"
+)
+
+type (
+	// MultiHost 同时对多个服务器下载或上传, 并行的连接分布到各个服务器,
+	// 速度合计到同一个 Statistic, 用于测量单个服务器无法跑满的链路
+	MultiHost []*SpeedtestClientWithHost
+
+	// hostPicker 为新的连接选择连接数最少的服务器
+	hostPicker struct {
+		mu        sync.Mutex
+		transfers []*hostTransfer
+	}
+
+	// HostError 某个服务器的连接出错, 同时下载或上传的其他连接也会结束
+	HostError struct {
+		Host string
+		Err  error
+	}
+)
+
+func (e *HostError) Error() string {
+	return e.Host + ": " + e.Err.Error()
+}
+
+func (e *HostError) Unwrap() error {
+	return e.Err
+}
+
+// hostError 为出错的连接附加服务器
+func hostError(t *hostTransfer, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &HostError{Host: t.sch.Host, Err: err}
+}
+
+// pick 选择连接数最少的服务器
+func (hp *hostPicker) pick() *hostTransfer {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	picked := hp.transfers[0]
+	for _, t := range hp.transfers[1:] {
+		if t.stat.streams < picked.stat.streams {
+			picked = t
+		}
+	}
+	picked.stat.streams++
+	return picked
+}
+
+// done 连接结束
+func (hp *hostPicker) done(t *hostTransfer) {
+	hp.mu.Lock()
+	t.stat.streams--
+	hp.mu.Unlock()
+}
+
+// option 并行数为 0 时使用服务器的数量, 小于服务器的数量时返回 ErrParallelLessThanHosts
+func (mh MultiHost) option(opt *UpDownloadOption) (*UpDownloadOption, error) {
+	o := UpDownloadOption{
+		Timeout:          15 * time.Second,
+		CallbackInterval: 500 * time.Millisecond,
+	}
+	if opt != nil {
+		o = *opt
+	}
+	if o.Parallel == 0 {
+		o.Parallel = len(mh)
+	} else if o.Parallel < len(mh) {
+		return nil, ErrParallelLessThanHosts
+	}
+	return &o, nil
+}
+
+// newPicker 准备对各服务器下载或上传
+func (mh MultiHost) newPicker(opt *UpDownloadOption, p *payload) (hp *hostPicker, hosts []*HostStatistic) {
+	hp = &hostPicker{}
+	for _, sch := range mh {
+		t := sch.newTransfer(opt, p)
+		t.stat = newHostStatistic(sch.Host)
+		hp.transfers = append(hp.transfers, t)
+		hosts = append(hosts, t.stat)
+	}
+	return
+}
+
+// Download 同时从各服务器下载, 每个服务器至少一个连接, 出错时返回 *HostError
+func (mh MultiHost) Download(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
+	if len(mh) == 0 {
+		return nil, ErrNoHosts
+	}
+	opt, err = mh.option(opt)
+	if err != nil {
+		return
+	}
+	hp, hosts := mh.newPicker(opt, nil)
+	for _, t := range hp.transfers {
+		t.sampler = opt.downloadSampler()
+	}
+	res, err = upDownloadHosts(opt, hosts, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
+		t := hp.pick()
+		defer hp.done(t)
+		errChan <- hostError(t, t.download(ctx, commonBuf, statistic, speedStat))
+	})
+	if res != nil {
+		for i, t := range hp.transfers {
+			t.fillRes(res.Hosts[i])
+		}
+	}
+	return
+}
+
+// Upload 同时向各服务器上传, 每个服务器至少一个连接, 出错时返回 *HostError
+func (mh MultiHost) Upload(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
+	if len(mh) == 0 {
+		return nil, ErrNoHosts
+	}
+	opt, err = mh.option(opt)
+	if err != nil {
+		return
+	}
+	p, err := opt.newPayload()
+	if err != nil {
+		return
+	}
+	defer p.Close()
+
+	hp, hosts := mh.newPicker(opt, p)
+	res, err = upDownloadHosts(opt, hosts, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
+		t := hp.pick()
+		defer hp.done(t)
+		errChan <- hostError(t, t.upload(ctx, statistic, speedStat))
+	})
+	if res != nil {
+		res.Payload = opt.payload().String()
+		for i, t := range hp.transfers {
+			t.fillRes(res.Hosts[i])
+			res.Hosts[i].Payload = res.Payload
+			res.ZeroCopy = res.ZeroCopy || res.Hosts[i].ZeroCopy
+		}
+	}
+	return
+}
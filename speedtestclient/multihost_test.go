@@ -0,0 +1,91 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMultiHost(t *testing.T) {
+	var mh speedtestclient.MultiHost
+	for i := 0; i < 2; i++ {
+		addr, closeFn := listenFake(t)
+		defer closeFn()
+		withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+		mh = append(mh, withHost)
+	}
+
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          300 * time.Millisecond,
+		Parallel:         4,
+		CallbackInterval: 100 * time.Millisecond,
+	}
+	for _, fn := range []func(*speedtestclient.UpDownloadOption, speedtestclient.UpDownloadCallback) (*speedtestclient.UpDownloadRes, error){mh.Download, mh.Upload} {
+		var callbackHosts int32
+		res, err := fn(opt, func(statistic *speedtestclient.Statistic) {
+			atomic.StoreInt32(&callbackHosts, int32(len(statistic.Hosts())))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&callbackHosts); int(n) != len(mh) {
+			t.Fatalf("callback hosts: %d\n", n)
+		}
+		if len(res.Hosts) != len(mh) {
+			t.Fatalf("hosts: %d\n", len(res.Hosts))
+		}
+		var sum int64
+		for i, hostRes := range res.Hosts {
+			if hostRes.Host != mh[i].Host || hostRes.AverageSpeed == 0 {
+				t.Fatalf("unexpected host result: %#v\n", hostRes)
+			}
+			sum += hostRes.AverageSpeed
+		}
+		t.Logf("total: %d, hosts sum: %d\n", res.AverageSpeed, sum)
+		if res.AverageSpeed == 0 {
+			t.Fatalf("unexpected result: %#v\n", res)
+		}
+	}
+}
+
+func TestMultiHostParallel(t *testing.T) {
+	mh := speedtestclient.MultiHost{
+		speedtestclient.NewSpeedtestClient().WithHost("127.0.0.1:1"),
+		speedtestclient.NewSpeedtestClient().WithHost("127.0.0.1:2"),
+	}
+	_, err := mh.Download(&speedtestclient.UpDownloadOption{Parallel: 1}, nil)
+	if err != speedtestclient.ErrParallelLessThanHosts {
+		t.Fatalf("expected ErrParallelLessThanHosts, got %v\n", err)
+	}
+}
+
+func TestMultiHostError(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+	// 已关闭的端口
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	mh := speedtestclient.MultiHost{
+		speedtestclient.NewSpeedtestClient().WithHost(addr),
+		speedtestclient.NewSpeedtestClient().WithHost(closedAddr),
+	}
+	_, err = mh.Download(&speedtestclient.UpDownloadOption{
+		Timeout:          time.Second,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+	}, nil)
+	hostErr, ok := err.(*speedtestclient.HostError)
+	if !ok {
+		t.Fatalf("expected *HostError, got %v\n", err)
+	}
+	if hostErr.Host != closedAddr {
+		t.Fatalf("expected host %s, got %s\n", closedAddr, hostErr.Host)
+	}
+}
@@ -0,0 +1,226 @@
+package speedtestclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/iikira/iikira-go-utils/utils/converter"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// PayloadRandom 随机数据, 不可压缩, 默认
+	PayloadRandom PayloadMode = iota
+	// PayloadZero 全零
+	PayloadZero
+	// PayloadPattern 重复的内容
+	PayloadPattern
+	// PayloadFile 文件的内容
+	PayloadFile
+)
+
+const (
+	// DefaultBufferSize 下载和上传默认的缓冲区大小
+	DefaultBufferSize = 128 * 1024
+	// payloadFileSize 用于 sendfile 的数据文件的最小大小, 也是读取文件内容的最大大小
+	payloadFileSize = 8 * converter.MB
+	// payloadFileDir 数据文件优先放在内存文件系统中.
+	// 标准库的 syscall 在部分架构 (如 amd64) 上没有 memfd_create, 为了不引入 golang.org/x/sys,
+	// 使用 tmpfs 上创建后立即删除的文件, 效果与 memfd 相同
+	payloadFileDir = "/dev/shm"
+)
+
+var (
+	// errZeroCopyUnsupported 连接或平台不支持零拷贝, 使用 Write
+	errZeroCopyUnsupported = errors.New("zero copy is not supported")
+)
+
+type (
+	// PayloadMode 上传数据的类型
+	PayloadMode int
+
+	// Payload 上传的数据
+	Payload struct {
+		Mode    PayloadMode
+		Pattern []byte // PayloadPattern 重复的内容
+		File    string // PayloadFile 文件的路径
+	}
+
+	// payload 预先生成的上传数据
+	payload struct {
+		data  []byte   // 循环发送的数据, 用于 Write
+		chunk int      // 每次发送的大小
+		file  *os.File // 用于 sendfile, 为 nil 则不使用零拷贝
+		size  int64    // file 的大小
+	}
+)
+
+// ParsePayload 解析上传数据的类型, random, zero, pattern:<内容> 或 file:<路径>
+func ParsePayload(s string) (*Payload, error) {
+	name, arg := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+	switch strings.ToLower(name) {
+	case "", "random":
+		return &Payload{Mode: PayloadRandom}, nil
+	case "zero":
+		return &Payload{Mode: PayloadZero}, nil
+	case "pattern":
+		if arg == "" {
+			break
+		}
+		return &Payload{Mode: PayloadPattern, Pattern: []byte(arg)}, nil
+	case "file":
+		if arg == "" {
+			break
+		}
+		return &Payload{Mode: PayloadFile, File: arg}, nil
+	}
+	return nil, fmt.Errorf("%s: %q", ErrInvalidPayload, s)
+}
+
+func (pl *Payload) String() string {
+	if pl == nil {
+		return "random"
+	}
+	switch pl.Mode {
+	case PayloadZero:
+		return "zero"
+	case PayloadPattern:
+		return "pattern:" + string(pl.Pattern)
+	case PayloadFile:
+		return "file:" + pl.File
+	}
+	return "random"
+}
+
+// newPayload 生成上传的数据, zeroCopy 为 true 时同时准备数据文件,
+// 准备数据文件失败时不使用零拷贝
+func newPayload(pl *Payload, bufSize int, zeroCopy bool) (p *payload, err error) {
+	if pl == nil {
+		pl = &Payload{}
+	}
+	p = &payload{
+		chunk: bufSize,
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	switch pl.Mode {
+	case PayloadRandom:
+		p.data = make([]byte, bufSize)
+		rnd.Read(p.data)
+	case PayloadZero:
+		p.data = make([]byte, bufSize)
+	case PayloadPattern:
+		if len(pl.Pattern) == 0 {
+			return nil, ErrInvalidPayload
+		}
+		// 保持完整的重复, 循环发送时不会打断内容
+		p.data = bytes.Repeat(pl.Pattern, (bufSize+len(pl.Pattern)-1)/len(pl.Pattern))
+	case PayloadFile:
+		return newFilePayload(pl.File, bufSize, zeroCopy)
+	default:
+		return nil, ErrInvalidPayload
+	}
+	if !zeroCopy || !zeroCopySupported {
+		return p, nil
+	}
+
+	file, err := newPayloadFile()
+	if err != nil {
+		return p, nil
+	}
+
+	// 随机数据时, 数据文件中的每一块都不同
+	chunk := p.data
+	for p.size < payloadFileSize || p.size < int64(bufSize) {
+		_, err = file.Write(chunk)
+		if err != nil {
+			file.Close()
+			return p, nil
+		}
+		p.size += int64(len(chunk))
+		if pl.Mode == PayloadRandom {
+			chunk = make([]byte, bufSize)
+			rnd.Read(chunk)
+		}
+	}
+	p.file = file
+	return p, nil
+}
+
+// newFilePayload 读取文件的内容, 零拷贝时直接发送该文件
+func newFilePayload(name string, bufSize int, zeroCopy bool) (p *payload, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return
+	}
+
+	p = &payload{
+		chunk: bufSize,
+	}
+	p.data, err = ioutil.ReadAll(io.LimitReader(file, payloadFileSize))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if len(p.data) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s is empty", ErrInvalidPayload, name)
+	}
+
+	info, err := file.Stat()
+	if err != nil || !zeroCopy || !zeroCopySupported || !info.Mode().IsRegular() {
+		file.Close()
+		return p, nil
+	}
+	p.file = file
+	p.size = info.Size()
+	return p, nil
+}
+
+// newPayloadFile 创建已经删除的临时文件, 在关闭后释放.
+// 没有 payloadFileDir 时放在 os.TempDir(), 可能位于磁盘上, 但数据只有 payloadFileSize, 发送时读自页缓存
+func newPayloadFile() (file *os.File, err error) {
+	dir := payloadFileDir
+	if _, err = os.Stat(dir); err != nil {
+		dir = os.TempDir()
+	}
+	file, err = ioutil.TempFile(dir, "speedtest-payload-")
+	if err != nil {
+		return
+	}
+	err = os.Remove(file.Name())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// next 返回从 *off 开始的不超过 chunk 的数据, 并移动 *off
+func (p *payload) next(off *int) []byte {
+	if *off >= len(p.data) {
+		*off = 0
+	}
+	end := *off + p.chunk
+	if end > len(p.data) {
+		end = len(p.data)
+	}
+	b := p.data[*off:end]
+	*off = end
+	return b
+}
+
+func (p *payload) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	return p.file.Close()
+}
@@ -0,0 +1,107 @@
+package speedtestclient_test
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/iikira/speedtest/speedtestclient"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParsePayload(t *testing.T) {
+	for _, s := range []string{"", "random", "zero", "pattern:abc", "file:/tmp/payload"} {
+		pl, err := speedtestclient.ParsePayload(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%q -> %s\n", s, pl)
+	}
+	for _, s := range []string{"pattern:", "file", "gzip"} {
+		_, err := speedtestclient.ParsePayload(s)
+		if err == nil {
+			t.Fatalf("%q: expected error\n", s)
+		}
+	}
+}
+
+// uploadTo 上传到只接收数据的服务端, 返回服务端收到的前 size 字节
+func uploadTo(t *testing.T, pl *speedtestclient.Payload, size int) []byte {
+	received := make(chan []byte, 4)
+	withHost := speedtestclient.NewSpeedtestClient().WithHost("pipe:8080")
+	withHost.SetDialer(speedtestclient.DialerFunc(func(network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			br := bufio.NewReader(server)
+			br.ReadString('\n')
+			buf := make([]byte, size)
+			_, err := io.ReadFull(br, buf)
+			if err == nil {
+				received <- buf
+			}
+			io.Copy(ioutil.Discard, br)
+		}()
+		return client, nil
+	}))
+
+	_, err := withHost.Upload(&speedtestclient.UpDownloadOption{
+		Timeout:          200 * time.Millisecond,
+		CallbackInterval: 100 * time.Millisecond,
+		BufferSize:       1000,
+		Payload:          pl,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return <-received
+}
+
+func TestUploadPayload(t *testing.T) {
+	data := uploadTo(t, &speedtestclient.Payload{Mode: speedtestclient.PayloadZero}, 4096)
+	if !bytes.Equal(data, make([]byte, 4096)) {
+		t.Fatal("unexpected zero payload")
+	}
+
+	data = uploadTo(t, &speedtestclient.Payload{Mode: speedtestclient.PayloadPattern, Pattern: []byte("abc")}, 4096)
+	if !bytes.Equal(data, bytes.Repeat([]byte("abc"), 4096/3+1)[:4096]) {
+		t.Fatal("unexpected pattern payload")
+	}
+
+	file, err := ioutil.TempFile("", "payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	content := bytes.Repeat([]byte("0123456789"), 300)
+	file.Write(content)
+	file.Close()
+	data = uploadTo(t, &speedtestclient.Payload{Mode: speedtestclient.PayloadFile, File: file.Name()}, 6000)
+	if !bytes.Equal(data, append(content, content...)) {
+		t.Fatal("unexpected file payload")
+	}
+
+	data = uploadTo(t, nil, 4096)
+	if bytes.Count(data, []byte{0}) > 512 {
+		t.Fatal("random payload looks compressible")
+	}
+}
+
+func TestDownloadSample(t *testing.T) {
+	// 模拟服务端发送全零的数据
+	res, err := newPipeHost().Download(&speedtestclient.UpDownloadOption{
+		Timeout:          200 * time.Millisecond,
+		CallbackInterval: 100 * time.Millisecond,
+		SampleDownload:   true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DownloadSample == nil || !res.DownloadSample.Compressible {
+		t.Fatalf("unexpected download check: %#v\n", res.DownloadSample)
+	}
+	t.Log(res.DownloadSample.Warnings())
+}
@@ -0,0 +1,278 @@
+package speedtestclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// ProxyHandshakeTimeout 与代理服务器握手的超时时间
+	ProxyHandshakeTimeout = 30 * time.Second
+)
+
+type (
+	// httpConnectDialer 通过 HTTP CONNECT 建立隧道
+	httpConnectDialer struct {
+		proxyURL *url.URL
+		forward  proxy.Dialer
+	}
+
+	// socks4Dialer SOCKS4 和 SOCKS4a
+	socks4Dialer struct {
+		proxyURL *url.URL
+		forward  proxy.Dialer
+	}
+
+	// bufferedConn 读取 CONNECT 响应时, 可能多读了数据.
+	// 通过 https 代理时底层为 TLS 连接, 无法读取 TCP_INFO, 也不能 zero_copy
+	bufferedConn struct {
+		net.Conn
+		br *bufio.Reader
+	}
+)
+
+// proxyDialer 按照代理的协议创建通过代理连接的 Dialer,
+// http, https, socks4 和 socks4a 由本包实现, 不注册到 proxy 包, 以免影响其他使用者
+func proxyDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return &httpConnectDialer{proxyURL: u, forward: forward}, nil
+	case "socks4", "socks4a":
+		return &socks4Dialer{proxyURL: u, forward: forward}, nil
+	}
+	return proxy.FromURL(u, forward)
+}
+
+// ParseProxyURL 解析代理地址, 支持 http, https, socks5, socks5h, socks4, socks4a,
+// 未指定协议时视为 http
+func ParseProxyURL(proxyAddr string) (u *url.URL, err error) {
+	if !strings.Contains(proxyAddr, "://") {
+		proxyAddr = "http://" + proxyAddr
+	}
+	u, err = url.Parse(proxyAddr)
+	if err != nil {
+		return
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h", "socks4", "socks4a":
+		return u, nil
+	}
+	return nil, ErrUnsupportedProxy
+}
+
+// SetDialProxy 设置测速数据通道使用的代理
+func (sch *SpeedtestClientWithHost) SetDialProxy(proxyAddr string) (err error) {
+	u, err := ParseProxyURL(proxyAddr)
+	if err != nil {
+		return
+	}
+	sch.proxyURL = u
+	return
+}
+
+// SetUseEnvironmentProxy 未设置代理时, 是否使用环境变量中的代理, 默认不使用,
+// 以免测速数据通道在不知情的情况下经过代理
+func (sch *SpeedtestClientWithHost) SetUseEnvironmentProxy(use bool) {
+	sch.useEnvProxy = use
+}
+
+// getProxyURL 返回连接 sch.Host 使用的代理, 无代理返回 nil
+func (sch *SpeedtestClientWithHost) getProxyURL() (u *url.URL, err error) {
+	if sch.proxyURL != nil {
+		return sch.proxyURL, nil
+	}
+	if !sch.useEnvProxy {
+		return nil, nil
+	}
+	return ProxyFromEnvironment(sch.Host)
+}
+
+// ProxyFromEnvironment 读取环境变量 HTTP_PROXY, ALL_PROXY, NO_PROXY,
+// 返回连接 host 使用的代理, 无代理返回 nil.
+// 测速服务器按 http 处理, HTTP_PROXY 优先, 未设置时使用 ALL_PROXY
+func ProxyFromEnvironment(host string) (u *url.URL, err error) {
+	proxyAddr := getEnvAny("HTTP_PROXY", "http_proxy")
+	if proxyAddr == "" {
+		proxyAddr = getEnvAny("ALL_PROXY", "all_proxy")
+	}
+	if proxyAddr == "" {
+		return nil, nil
+	}
+
+	// 借助 httpproxy 判断 host 是否匹配 NO_PROXY
+	config := httpproxy.Config{
+		HTTPProxy: "http://proxy",
+		NoProxy:   getEnvAny("NO_PROXY", "no_proxy"),
+	}
+	pu, err := config.ProxyFunc()(&url.URL{Scheme: "http", Host: host})
+	if err != nil || pu == nil {
+		return nil, err
+	}
+
+	return ParseProxyURL(proxyAddr)
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// proxyAddr 返回代理服务器地址, 未指定端口则使用默认端口
+func proxyAddr(u *url.URL, defaultPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (conn net.Conn, err error) {
+	defaultPort := "80"
+	if d.proxyURL.Scheme == "https" {
+		defaultPort = "443"
+	}
+	conn, err = d.forward.Dial(network, proxyAddr(d.proxyURL, defaultPort))
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(ProxyHandshakeTimeout))
+
+	if d.proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: d.proxyURL.Hostname(),
+		})
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy: CONNECT %s: %s", addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, br: br}, nil
+	}
+	return conn, nil
+}
+
+func (d *socks4Dialer) Dial(network, addr string) (conn net.Conn, err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return
+	}
+
+	// VN CD DSTPORT DSTIP USERID NULL [HOSTNAME NULL]
+	req := []byte{4, 1, byte(port >> 8), byte(port)}
+	ip := net.ParseIP(host).To4()
+	if ip == nil && d.proxyURL.Scheme == "socks4" {
+		// SOCKS4 需要在本地解析
+		ipAddr, err := net.ResolveIPAddr("ip4", host)
+		if err != nil {
+			return nil, err
+		}
+		ip = ipAddr.IP.To4()
+	}
+	if ip != nil {
+		req = append(req, ip...)
+	} else {
+		req = append(req, 0, 0, 0, 1) // SOCKS4a, 由代理服务器解析
+	}
+	if d.proxyURL.User != nil {
+		req = append(req, d.proxyURL.User.Username()...)
+	}
+	req = append(req, 0)
+	if ip == nil {
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+
+	conn, err = d.forward.Dial(network, proxyAddr(d.proxyURL, "1080"))
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(ProxyHandshakeTimeout))
+
+	_, err = conn.Write(req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp := make([]byte, 8)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp[1] != 90 { // 90: request granted
+		conn.Close()
+		return nil, fmt.Errorf("proxy: SOCKS4 request rejected, code: %d", resp[1])
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (bc *bufferedConn) Read(b []byte) (n int, err error) {
+	return bc.br.Read(b)
+}
+
+// SyscallConn 返回底层连接的 syscall.RawConn, 用于读取 TCP_INFO 和 zero_copy
+func (bc *bufferedConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := bc.Conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrNotSyscallConn
+	}
+	return sc.SyscallConn()
+}
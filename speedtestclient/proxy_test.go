@@ -0,0 +1,224 @@
+package speedtestclient_test
+
+import (
+	"bufio"
+	"github.com/iikira/speedtest/speedtestclient"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// listenConnectProxy 在本地监听一个要求 basic 认证的 HTTP CONNECT 代理
+func listenConnectProxy(t *testing.T) (addr string, closeFn func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != "CONNECT" || req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	serverAddr, closeServer := listenFake(t)
+	defer closeServer()
+	proxyAddr, closeProxy := listenConnectProxy(t)
+	defer closeProxy()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(serverAddr)
+	err := withHost.SetDialProxy("http://user:wrong@" + proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = withHost.HI()
+	if err == nil {
+		t.Fatal("expected error with wrong password")
+	}
+
+	err = withHost.SetDialProxy("http://user:pass@" + proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%#v\n", res)
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8080", "socks5://u:p@127.0.0.1", "socks4a://127.0.0.1:1080", "https://proxy:443"} {
+		if _, err := speedtestclient.ParseProxyURL(addr); err != nil {
+			t.Fatalf("%s: %s\n", addr, err)
+		}
+	}
+	if _, err := speedtestclient.ParseProxyURL("ftp://127.0.0.1"); err != speedtestclient.ErrUnsupportedProxy {
+		t.Fatalf("expected ErrUnsupportedProxy, got %v\n", err)
+	}
+}
+
+// listenSocks4Proxy 在本地监听一个 SOCKS4/SOCKS4a 代理, hosts 接收请求中的目标地址
+func listenSocks4Proxy(t *testing.T) (addr string, hosts chan string, closeFn func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts = make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				head := make([]byte, 8)
+				if _, err := io.ReadFull(br, head); err != nil || head[0] != 4 || head[1] != 1 {
+					return
+				}
+				if _, err := br.ReadString(0); err != nil { // USERID
+					return
+				}
+				host := net.IP(head[4:8]).String()
+				if head[4] == 0 && head[5] == 0 && head[6] == 0 && head[7] != 0 { // SOCKS4a
+					host, err = br.ReadString(0)
+					if err != nil {
+						return
+					}
+					host = host[:len(host)-1]
+				}
+				hostPort := net.JoinHostPort(host, strconv.Itoa(int(head[2])<<8|int(head[3])))
+				hosts <- hostPort
+
+				target, err := net.Dial("tcp", hostPort)
+				if err != nil {
+					conn.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte{0, 90, 0, 0, 0, 0, 0, 0})
+				go io.Copy(target, br)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String(), hosts, func() { ln.Close() }
+}
+
+func TestSocks4Proxy(t *testing.T) {
+	serverAddr, closeServer := listenFake(t)
+	defer closeServer()
+	proxyAddr, hosts, closeProxy := listenSocks4Proxy(t)
+	defer closeProxy()
+	_, port, _ := net.SplitHostPort(serverAddr)
+
+	cases := []struct {
+		scheme string
+		host   string
+		want   string // 代理服务器收到的目标地址
+	}{
+		{"socks4", serverAddr, serverAddr},
+		{"socks4", "localhost:" + port, serverAddr}, // SOCKS4 在本地解析
+		{"socks4a", "localhost:" + port, "localhost:" + port},
+	}
+	for _, c := range cases {
+		withHost := speedtestclient.NewSpeedtestClient().WithHost(c.host)
+		err := withHost.SetDialProxy(c.scheme + "://" + proxyAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = withHost.HI()
+		if err != nil {
+			t.Fatalf("%s %s: %s\n", c.scheme, c.host, err)
+		}
+		if got := <-hosts; got != c.want {
+			t.Errorf("%s %s: proxy got %s, want %s\n", c.scheme, c.host, got, c.want)
+		}
+	}
+}
+
+func TestEnvironmentProxyOptIn(t *testing.T) {
+	proxyAddr, hosts, closeProxy := listenSocks4Proxy(t)
+	defer closeProxy()
+	// 回环地址不经过环境变量中的代理, 使用由代理服务器解析的域名
+	t.Setenv("ALL_PROXY", "socks4a://"+proxyAddr)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+	const host = "speedtest.invalid:8080"
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(host)
+	withHost.HI()
+	select {
+	case got := <-hosts:
+		t.Fatalf("environment proxy should not be used by default, proxy got %s\n", got)
+	default:
+	}
+
+	withHost.SetUseEnvironmentProxy(true)
+	withHost.HI()
+	select {
+	case got := <-hosts:
+		if got != host {
+			t.Errorf("proxy got %s, want %s\n", got, host)
+		}
+	default:
+		t.Fatal("environment proxy is not used")
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"http_proxy", "all_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+	const host = "speedtest.invalid:8080"
+	for _, c := range []struct {
+		httpProxy, allProxy, want string
+	}{
+		{"http://http.proxy:3128", "socks5://all.proxy:1080", "http://http.proxy:3128"},
+		{"", "socks5://all.proxy:1080", "socks5://all.proxy:1080"},
+		{"", "", ""},
+	} {
+		t.Setenv("HTTP_PROXY", c.httpProxy)
+		t.Setenv("ALL_PROXY", c.allProxy)
+		u, err := speedtestclient.ProxyFromEnvironment(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != c.want {
+			t.Errorf("HTTP_PROXY=%q ALL_PROXY=%q: got %q, want %q\n", c.httpProxy, c.allProxy, got, c.want)
+		}
+	}
+}
@@ -9,27 +9,46 @@ import (
 type (
 	// HIRes HI 结果
 	HIRes struct {
+		ConnInfo
 		Message string
+		Info    *ServerInfo // 解析后的服务端信息
+		Setup   ConnTiming  // 建立连接的用时, 不包括在 Latency 中
 		Latency time.Duration
 	}
 
 	// PingRes PING 结果
 	PingRes struct {
+		ConnInfo
 		Latencies []time.Duration
 		Average   time.Duration
 		Min       time.Duration
 		Max       time.Duration
 		Median    time.Duration
+
+		// 由 PONG 的服务端时间戳估算, 见 EstimateClock
+		ClockOffset     time.Duration // 服务端时钟减去本地时钟
+		UpstreamDelay   time.Duration // 估算的上行单向延时
+		DownstreamDelay time.Duration // 估算的下行单向延时
+		Asymmetry       time.Duration // 上行延时减去下行延时
 	}
 
 	// UpDownloadRes 下载或上传的结果
 	UpDownloadRes struct {
+		ConnInfo
 		TimeElapsed       time.Duration
 		SpeedsPerSecond   []int64
 		MaxSpeedPerSecond int64
 		MinSpeedPerSecond int64
 		AverageSpeed      int64
 		MedianSpeed       int64
+		TCPInfo           *TCPStats        // 各连接的 TCP_INFO, 仅 Linux
+		TCPOptions        *TCPOptions      // 设置的 TCP 选项, 没有设置则为 nil
+		MPTCP             *MPTCPStats      // 各连接的 MPTCP 协商结果, 没有开启 MPTCP 则为 nil
+		ZeroCopy          bool             // 上传是否使用了 sendfile
+		Payload           string           // 上传的数据类型
+		DownloadSample    *DownloadSample  // 下载数据开头的采样结果
+		Host              string           // 多服务器测速时该结果所属的服务器
+		Hosts             []*UpDownloadRes // 多服务器测速时各服务器的结果
 	}
 
 	PingCallback func(seq int, latency time.Duration)
@@ -71,6 +90,28 @@ func NewPingRes(latencies []time.Duration) *PingRes {
 	return &res
 }
 
+// Loss 超时的 PING 所占的比例, 0 到 1
+func (res *PingRes) Loss() float64 {
+	if len(res.Latencies) == 0 {
+		return 0
+	}
+	var lost int
+	for _, latency := range res.Latencies {
+		if latency == -1 {
+			lost++
+		}
+	}
+	return float64(lost) / float64(len(res.Latencies))
+}
+
+// SetClockEstimate 设置时钟偏差及单向延时
+func (res *PingRes) SetClockEstimate(est *ClockEstimate) {
+	res.ClockOffset = est.Offset
+	res.UpstreamDelay = est.UpstreamDelay
+	res.DownstreamDelay = est.DownstreamDelay
+	res.Asymmetry = est.Asymmetry
+}
+
 func NewUpDownloadRes(timeElapsed time.Duration, statistic *Statistic) *UpDownloadRes {
 	speedsLen := len(statistic.speedPerSeconds)
 	res := UpDownloadRes{
@@ -78,6 +119,10 @@ func NewUpDownloadRes(timeElapsed time.Duration, statistic *Statistic) *UpDownlo
 		SpeedsPerSecond: make([]int64, 0, speedsLen),
 	}
 
+	if statistic.tcpInfo != nil {
+		res.TCPInfo = statistic.tcpInfo.stats()
+	}
+
 	if speedsLen == 0 {
 		return &res
 	}
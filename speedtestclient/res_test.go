@@ -0,0 +1,24 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"testing"
+	"time"
+)
+
+func TestPingResLoss(t *testing.T) {
+	for _, c := range []struct {
+		latencies []time.Duration
+		loss      float64
+	}{
+		{nil, 0},
+		{[]time.Duration{time.Millisecond, 2 * time.Millisecond}, 0},
+		{[]time.Duration{time.Millisecond, -1, 3 * time.Millisecond, -1}, 0.5},
+		{[]time.Duration{-1}, 1},
+	} {
+		res := speedtestclient.NewPingRes(c.latencies)
+		if loss := res.Loss(); loss != c.loss {
+			t.Fatalf("latencies %v: loss %v, want %v\n", c.latencies, loss, c.loss)
+		}
+	}
+}
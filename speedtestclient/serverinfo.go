@@ -0,0 +1,141 @@
+package speedtestclient
+
+import (
+	"github.com/olekukonko/tablewriter"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ServerBuildTimeLayout HELLO 中构建时间的格式, 如 2020-11-10.1948
+	ServerBuildTimeLayout = "2006-01-02.1504"
+	// InventoryParallel Inventory 同时执行 HI 的服务器数量
+	InventoryParallel = 8
+)
+
+type (
+	// ServerInfo HI 返回的服务端信息,
+	// 如 HELLO 2.9 (2.9.3) 2020-11-10.1948.a0bb7f8
+	ServerInfo struct {
+		Raw       string    // 原始响应
+		Version   string    // 协议版本, 如 2.9
+		Build     string    // 构建版本, 如 2.9.3
+		BuildTime time.Time // 构建时间, 解析失败则为零值
+		Commit    string    // 构建提交, 如 a0bb7f8
+	}
+
+	// ServerInventoryItem 服务器清单中的一项
+	ServerInventoryItem struct {
+		Server  *SpeedtestServer
+		Info    *ServerInfo
+		Latency time.Duration
+		Err     error
+	}
+
+	// ServerInventory 服务器清单
+	ServerInventory []*ServerInventoryItem
+)
+
+// ParseServerInfo 解析 HI 的响应
+func ParseServerInfo(message string) (info *ServerInfo, err error) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 || fields[0] != "HELLO" {
+		return nil, ErrHiResponse
+	}
+
+	info = &ServerInfo{
+		Raw: message,
+	}
+	if len(fields) > 1 {
+		info.Version = fields[1]
+	}
+	if len(fields) > 2 {
+		info.Build = strings.TrimSuffix(strings.TrimPrefix(fields[2], "("), ")")
+	}
+	if len(fields) > 3 {
+		// 2020-11-10.1948.a0bb7f8
+		stamp := fields[3]
+		if i := strings.LastIndexByte(stamp, '.'); i > 0 && strings.Count(stamp, ".") >= 2 {
+			info.Commit = stamp[i+1:]
+			stamp = stamp[:i]
+		}
+		info.BuildTime, _ = time.Parse(ServerBuildTimeLayout, stamp)
+	}
+	return info, nil
+}
+
+func (info *ServerInfo) String() string {
+	s := "version " + info.Version
+	if info.Build != "" {
+		s += ", build " + info.Build
+	}
+	if !info.BuildTime.IsZero() {
+		s += ", built " + info.BuildTime.Format("2006-01-02 15:04")
+	}
+	return s
+}
+
+// Inventory 对每个服务器执行 HI, 获取服务端版本, 最多同时对 InventoryParallel 个服务器执行,
+// 结果的顺序与 servList 相同
+func (sc *SpeedtestClient) Inventory(servList SpeedtestServerList) (inventory ServerInventory) {
+	items := make(ServerInventory, len(servList))
+	sem := make(chan struct{}, InventoryParallel)
+	wg := sync.WaitGroup{}
+	for i, server := range servList {
+		if server == nil {
+			continue
+		}
+		items[i] = &ServerInventoryItem{
+			Server: server,
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *ServerInventoryItem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res, err := sc.WithHost(item.Server.Host).HI()
+			if err != nil {
+				item.Err = err
+				return
+			}
+			item.Info = res.Info
+			item.Latency = res.Latency
+		}(items[i])
+	}
+	wg.Wait()
+
+	inventory = make(ServerInventory, 0, len(items))
+	for _, item := range items {
+		if item != nil {
+			inventory = append(inventory, item)
+		}
+	}
+	return
+}
+
+func (inventory ServerInventory) PrintTo(w io.Writer) {
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetColumnSeparator("")
+	table.SetHeader([]string{"ID", "SPONSOR", "HOST", "VERSION", "BUILD", "BUILD TIME", "LATENCY", "ERROR"})
+	for _, v := range inventory {
+		if v.Err != nil {
+			table.Append([]string{strconv.Itoa(v.Server.ID), v.Server.Sponsor, v.Server.Host, "", "", "", "", v.Err.Error()})
+			continue
+		}
+		var buildTime string
+		if !v.Info.BuildTime.IsZero() {
+			buildTime = v.Info.BuildTime.Format("2006-01-02 15:04")
+		}
+		table.Append([]string{strconv.Itoa(v.Server.ID), v.Server.Sponsor, v.Server.Host, v.Info.Version, v.Info.Build, buildTime, v.Latency.String(), ""})
+	}
+	table.Render()
+	return
+}
@@ -0,0 +1,55 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"testing"
+)
+
+func TestParseServerInfo(t *testing.T) {
+	info, err := speedtestclient.ParseServerInfo("HELLO 2.9 (2.9.3) 2020-11-10.1948.a0bb7f8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "2.9" || info.Build != "2.9.3" || info.Commit != "a0bb7f8" {
+		t.Fatalf("unexpected info: %#v\n", info)
+	}
+	if info.BuildTime.Format("2006-01-02 15:04") != "2020-11-10 19:48" {
+		t.Fatalf("unexpected build time: %s\n", info.BuildTime)
+	}
+	info, err = speedtestclient.ParseServerInfo("HELLO 2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "2.1" || info.Build != "" || !info.BuildTime.IsZero() {
+		t.Fatalf("unexpected info: %#v\n", info)
+	}
+
+	_, err = speedtestclient.ParseServerInfo("PONG 1")
+	if err != speedtestclient.ErrHiResponse {
+		t.Fatalf("expected ErrHiResponse, got %v\n", err)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	var servList speedtestclient.SpeedtestServerList
+	for i := 0; i < speedtestclient.InventoryParallel*2; i++ {
+		addr, closeFn := listenFake(t)
+		defer closeFn()
+		servList = append(servList, &speedtestclient.SpeedtestServer{ID: i, Host: addr})
+	}
+	// 无法连接的服务器和 nil 不影响其他服务器
+	servList = append(servList, nil, &speedtestclient.SpeedtestServer{ID: -1, Host: "127.0.0.1:1"})
+
+	inventory := speedtestclient.NewSpeedtestClient().Inventory(servList)
+	if len(inventory) != len(servList)-1 {
+		t.Fatalf("inventory has %d items, want %d\n", len(inventory), len(servList)-1)
+	}
+	for i, item := range inventory[:len(inventory)-1] {
+		if item.Server.ID != i || item.Err != nil || item.Info == nil {
+			t.Fatalf("unexpected item %d: %#v\n", i, item)
+		}
+	}
+	if last := inventory[len(inventory)-1]; last.Server.ID != -1 || last.Err == nil {
+		t.Fatalf("expected error for unreachable server: %#v\n", last)
+	}
+}
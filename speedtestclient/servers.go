@@ -3,13 +3,16 @@ package speedtestclient
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"github.com/iikira/iikira-go-utils/requester/rio/speeds"
 	"github.com/iikira/iikira-go-utils/utils/cachepool"
 	"github.com/iikira/iikira-go-utils/utils/converter"
-	"github.com/iikira/speedtest/speedtestutil/bytemessage"
 	"golang.org/x/net/proxy"
 	"net"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,17 +25,52 @@ const (
 )
 
 type (
+	// hostConn 到测速服务器的连接
+	hostConn struct {
+		net.Conn          // 经过传输方式握手后的连接
+		raw      net.Conn // 底层的连接, 使用代理时为到代理服务器的连接
+		timing   ConnTiming
+	}
+
 	SpeedtestClientWithHost struct {
 		SpeedtestClient
-		Host      string
-		socks5URL *url.URL
-		localAddr *net.TCPAddr
+		Host        string
+		proxyURL    *url.URL
+		useEnvProxy bool
+		transport   Transport
+		tlsConfig   *tls.Config
+		dialer      Dialer
+		network     string
+		mptcp       bool
+
+		resolveOverrides []*ResolveOverride
+		dnsServer        string
+		dnsMu            sync.Mutex
+		dnsRes           *DNSRes            // 缓存的解析结果
+		connInfo         atomic.Value       // 最近一次建立的连接的信息, ConnInfo
+		connTimings      connTimingRecorder // 建立连接的用时
 	}
 
 	UpDownloadOption struct {
 		Timeout          time.Duration
 		Parallel         int
 		CallbackInterval time.Duration // 回调函数调用的时间间隔
+		BufferSize       int           // 每次读写的缓冲区大小, 为 0 则使用 DefaultBufferSize
+		ZeroCopy         bool          // 上传时使用 sendfile, 仅 Linux 的 TCP 传输方式, 不支持时使用 Write, 下载总是使用 Read
+		Payload          *Payload      // 上传的数据, 为 nil 则使用随机数据
+		SampleDownload   bool          // 采样下载数据的开头, 检查可压缩性
+		TCPOptions                     // 测速连接的 TCP 选项, 不影响 HI 和 PING
+	}
+
+	// hostTransfer 对一个服务器下载或上传时, 各连接共用的状态
+	hostTransfer struct {
+		sch      *SpeedtestClientWithHost
+		tcpOpts  *TCPOptions
+		sampler  *downloadSampler
+		mptcp    *mptcpRecorder
+		payload  *payload       // 上传的数据
+		zeroCopy int32          // 是否有连接使用了零拷贝
+		stat     *HostStatistic // 多服务器测速时该服务器的统计, 否则为 nil
 	}
 
 	upDownloadHandleFunc func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds)
@@ -45,6 +83,7 @@ func (sc *SpeedtestClient) WithHost(host string) *SpeedtestClientWithHost {
 	}
 }
 
+// SetSocks5Proxy 设置 socks5 代理, 其他代理使用 SetDialProxy
 func (sch *SpeedtestClientWithHost) SetSocks5Proxy(proxyAddr string) (err error) {
 	u, err := url.Parse(proxyAddr)
 	if err != nil {
@@ -54,36 +93,73 @@ func (sch *SpeedtestClientWithHost) SetSocks5Proxy(proxyAddr string) (err error)
 		err = ErrNotSocks5Proxy
 		return
 	}
-	sch.socks5URL = u
+	sch.proxyURL = u
 	return
 }
 
-func (sch *SpeedtestClientWithHost) SetLocalAddr(localAddr *net.TCPAddr) {
-	sch.localAddr = localAddr
-}
-
-func (sch *SpeedtestClientWithHost) dialHost() (tcpConn *net.TCPConn, err error) {
-	var dialer proxy.Dialer
-	dialer = &net.Dialer{
-		LocalAddr: sch.localAddr,
+// dialHost 建立到 sch.Host 的连接, 并按照传输方式进行握手
+func (sch *SpeedtestClientWithHost) dialHost(tcpOpts *TCPOptions) (conn *hostConn, err error) {
+	var dialer proxy.Dialer = sch.baseDialer(tcpOpts)
+	proxyURL, err := sch.getProxyURL()
+	if err != nil {
+		return
 	}
-	if sch.socks5URL != nil {
-		dialer, err = proxy.FromURL(sch.socks5URL, dialer)
+	if proxyURL != nil {
+		dialer, err = proxyDialer(proxyURL, dialer)
 		if err != nil {
 			return
 		}
 	}
 
-	conn, err := dialer.Dial("tcp", sch.Host)
+	var timing ConnTiming
+	start := time.Now()
+	addrs, dnsTime, err := sch.dialAddrs(proxyURL != nil)
 	if err != nil {
 		return
 	}
-	tcpConn = conn.(*net.TCPConn)
-	return
+	timing.DNS = dnsTime
+
+	// 依次尝试解析得到的地址, 直到连接成功
+	var raw net.Conn
+	connectStart := time.Now()
+	for _, addr := range addrs {
+		raw, err = dialer.Dial(sch.getNetwork(), addr)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	timing.Connect = time.Since(connectStart)
+	err = tcpOpts.applyConn(raw)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	sch.storeConnInfo(raw.RemoteAddr())
+
+	handshakeStart := time.Now()
+	upgraded, err := sch.upgradeConn(raw)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if upgraded != raw {
+		timing.Handshake = time.Since(handshakeStart)
+	}
+	timing.Total = time.Since(start)
+	sch.connTimings.add(timing)
+
+	return &hostConn{
+		Conn:   upgraded,
+		raw:    raw,
+		timing: timing,
+	}, nil
 }
 
 func (sch *SpeedtestClientWithHost) HI() (res *HIRes, err error) {
-	conn, err := sch.dialHost()
+	conn, err := sch.dialHost(nil)
 	if err != nil {
 		return
 	}
@@ -91,7 +167,7 @@ func (sch *SpeedtestClientWithHost) HI() (res *HIRes, err error) {
 
 	conn.SetDeadline(time.Now().Add(HiTimeout))
 	nowTime := time.Now()
-	_, err = conn.Write(bytemessage.Smessagef("HI\n"))
+	err = conn.writeCommand("HI\n")
 	if err != nil {
 		return
 	}
@@ -102,14 +178,20 @@ func (sch *SpeedtestClientWithHost) HI() (res *HIRes, err error) {
 		return
 	}
 
-	if bytes.Index(buf[:n], []byte("HELLO")) != 0 {
-		err = ErrHiResponse
+	latency := time.Since(nowTime)
+
+	message := string(bytes.TrimSuffix(buf[:n], []byte{'\n'}))
+	info, err := ParseServerInfo(message)
+	if err != nil {
 		return
 	}
 
 	res = &HIRes{
-		Message: converter.ToString(bytes.TrimSuffix(buf[:n], []byte{'\n'})),
-		Latency: time.Since(nowTime),
+		ConnInfo: sch.ConnInfo(),
+		Setup:    conn.timing,
+		Message:  message,
+		Info:     info,
+		Latency:  latency,
 	}
 	return
 }
@@ -120,7 +202,7 @@ func (sch *SpeedtestClientWithHost) Ping(times int, sleep time.Duration, callbac
 		return
 	}
 
-	conn, err := sch.dialHost()
+	conn, err := sch.dialHost(nil)
 	if err != nil {
 		return
 	}
@@ -129,12 +211,13 @@ func (sch *SpeedtestClientWithHost) Ping(times int, sleep time.Duration, callbac
 	var (
 		buf       = make([]byte, 256)
 		latencies = make([]time.Duration, 0, times)
+		samples   = make([]PingSample, 0, times)
 		n         int
 	)
 	for i := 0; i < times; i++ {
 		conn.SetDeadline(time.Now().Add(PingTimeout))
 		nowTime := time.Now()
-		_, err = conn.Write(bytemessage.Smessagef("PING %d\n", nowTime.UnixNano()/1e6))
+		err = conn.writeCommand("PING %d\n", nowTime.UnixNano()/1e6)
 		if err != nil {
 			if IsTimeout(err) {
 				latencies = append(latencies, -1)
@@ -154,7 +237,8 @@ func (sch *SpeedtestClientWithHost) Ping(times int, sleep time.Duration, callbac
 		}
 
 		// 计算延时
-		latency := time.Since(nowTime)
+		receivedTime := time.Now()
+		latency := receivedTime.Sub(nowTime)
 
 		fields := bytes.Fields(bytes.TrimSuffix(buf[:n], []byte{'\n'}))
 		if len(fields) != 2 {
@@ -166,6 +250,16 @@ func (sch *SpeedtestClientWithHost) Ping(times int, sleep time.Duration, callbac
 			return
 		}
 
+		// PONG <server ms>
+		serverMs, parseErr := strconv.ParseInt(string(fields[1]), 10, 64)
+		if parseErr == nil {
+			samples = append(samples, PingSample{
+				Sent:       nowTime,
+				Received:   receivedTime,
+				ServerTime: time.Unix(0, serverMs*int64(time.Millisecond)),
+			})
+		}
+
 		latencies = append(latencies, latency)
 		if callback != nil {
 			callback(i, latency)
@@ -175,10 +269,60 @@ func (sch *SpeedtestClientWithHost) Ping(times int, sleep time.Duration, callbac
 
 	err = nil
 	res = NewPingRes(latencies)
+	res.ConnInfo = sch.ConnInfo()
+	if len(samples) > 0 {
+		res.SetClockEstimate(EstimateClock(samples))
+	}
 	return
 }
 
-func (sch *SpeedtestClientWithHost) upDownload(opt *UpDownloadOption, callback UpDownloadCallback, gofn upDownloadHandleFunc) (res *UpDownloadRes, err error) {
+// tcpOptions 返回设置的 TCP 选项, 没有设置则返回 nil
+func (opt *UpDownloadOption) tcpOptions() *TCPOptions {
+	if opt == nil || opt.TCPOptions.IsZero() {
+		return nil
+	}
+	tcpOpts := opt.TCPOptions
+	return &tcpOpts
+}
+
+// bufferSize 返回缓冲区大小
+func (opt *UpDownloadOption) bufferSize() int {
+	if opt == nil || opt.BufferSize <= 0 {
+		return DefaultBufferSize
+	}
+	return opt.BufferSize
+}
+
+// payload 返回上传的数据类型
+func (opt *UpDownloadOption) payload() *Payload {
+	if opt == nil {
+		return nil
+	}
+	return opt.Payload
+}
+
+// downloadSampler 需要采样下载数据时返回 downloadSampler, 否则返回 nil
+func (opt *UpDownloadOption) downloadSampler() *downloadSampler {
+	if opt == nil || !opt.SampleDownload {
+		return nil
+	}
+	return newDownloadSampler()
+}
+
+// newPayload 生成上传的数据
+func (opt *UpDownloadOption) newPayload() (*payload, error) {
+	if opt == nil {
+		return newPayload(nil, DefaultBufferSize, false)
+	}
+	return newPayload(opt.Payload, opt.bufferSize(), opt.ZeroCopy)
+}
+
+func upDownload(opt *UpDownloadOption, callback UpDownloadCallback, gofn upDownloadHandleFunc) (res *UpDownloadRes, err error) {
+	return upDownloadHosts(opt, nil, callback, gofn)
+}
+
+// upDownloadHosts 同 upDownload, hosts 为多服务器测速时各服务器的统计
+func upDownloadHosts(opt *UpDownloadOption, hosts []*HostStatistic, callback UpDownloadCallback, gofn upDownloadHandleFunc) (res *UpDownloadRes, err error) {
 	if opt == nil {
 		opt = &UpDownloadOption{
 			Timeout:          15 * time.Second,
@@ -195,16 +339,21 @@ func (sch *SpeedtestClientWithHost) upDownload(opt *UpDownloadOption, callback U
 			totalSize:       UpDownloadSize,
 			speedPerSeconds: make([]int64, 0, 32),
 			deadline:        time.Now().Add(opt.Timeout),
+			tcpInfo:         newTCPInfoRecorder(),
+			hosts:           hosts,
 		}
 		speedStat   = speeds.Speeds{} // 计算速度
 		ticker      = time.NewTicker(opt.CallbackInterval)
 		ctx, cancel = context.WithDeadline(context.Background(), statistic.deadline)
-		commonBuf   = cachepool.RawMallocByteSlice(2048)
+		commonBuf   = cachepool.RawMallocByteSlice(opt.bufferSize())
 		errChan     = make(chan error, opt.Parallel)
 	)
 	defer cancel()
 
 	statistic.StartTimer() // 开始计时
+	for _, h := range hosts {
+		h.startTime, h.deadline = statistic.startTime, statistic.deadline
+	}
 	for i := 0; i < opt.Parallel; i++ {
 		go gofn(ctx, commonBuf, errChan, &statistic, &speedStat)
 	}
@@ -233,6 +382,10 @@ func (sch *SpeedtestClientWithHost) upDownload(opt *UpDownloadOption, callback U
 			case <-ticker.C:
 				speed := speedStat.GetSpeeds()
 				statistic.AppendSpeedPerSecond(speed)
+				statistic.tcpInfo.sample()
+				for _, h := range hosts {
+					h.sample()
+				}
 
 				// 更新统计
 				statistic.speedPerSecond = speed
@@ -247,88 +400,190 @@ func (sch *SpeedtestClientWithHost) upDownload(opt *UpDownloadOption, callback U
 
 	<-ctx.Done()
 	ticker.Stop()
+	statistic.tcpInfo.sample()
 
 	elapsed := statistic.Elapsed()
 	res = NewUpDownloadRes(elapsed, &statistic)
+	for _, h := range hosts {
+		h.tcpInfo.sample()
+		hostRes := NewUpDownloadRes(elapsed, &h.Statistic)
+		hostRes.Host = h.Host
+		res.Hosts = append(res.Hosts, hostRes)
+	}
 	return
 }
 
-func (sch *SpeedtestClientWithHost) Download(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
-	return sch.upDownload(opt, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
-		conn, err := sch.dialHost()
-		if err != nil {
-			errChan <- err
+// newTransfer 准备对该服务器下载或上传, p 为上传的数据
+func (sch *SpeedtestClientWithHost) newTransfer(opt *UpDownloadOption, p *payload) *hostTransfer {
+	return &hostTransfer{
+		sch:     sch,
+		tcpOpts: opt.tcpOptions(),
+		mptcp:   sch.newMPTCPRecorder(),
+		payload: p,
+	}
+}
+
+// add 记录传输的数据量
+func (t *hostTransfer) add(n int64, statistic *Statistic, speedStat *speeds.Speeds) {
+	speedStat.Add(n)
+	statistic.AddTransferSize(n) // 增加
+	if t.stat != nil {
+		t.stat.speedStat.Add(n)
+		t.stat.AddTransferSize(n)
+	}
+}
+
+// dial 建立测速连接, 并记录连接的 TCP_INFO 和 MPTCP 协商结果
+func (t *hostTransfer) dial(statistic *Statistic) (conn *hostConn, err error) {
+	conn, err = t.sch.dialHost(t.tcpOpts)
+	if err != nil {
+		return nil, err
+	}
+	statistic.tcpInfo.add(conn)
+	if t.stat != nil {
+		t.stat.tcpInfo.add(conn)
+	}
+	t.mptcp.add(conn)
+	return conn, nil
+}
+
+// close 关闭测速连接
+func (t *hostTransfer) close(conn *hostConn, statistic *Statistic) {
+	statistic.tcpInfo.remove(conn)
+	if t.stat != nil {
+		t.stat.tcpInfo.remove(conn)
+	}
+	conn.Close()
+}
+
+// download 使用一个连接下载, 直到结束或者出错
+func (t *hostTransfer) download(ctx context.Context, commonBuf []byte, statistic *Statistic, speedStat *speeds.Speeds) (err error) {
+	conn, err := t.dial(statistic)
+	if err != nil {
+		return
+	}
+	defer t.close(conn, statistic)
+
+	// 1分钟
+	// 超时会产生错误：io.EOF
+	after := time.After(1 * time.Minute)
+	err = conn.writeCommand("DOWNLOAD %d\n", UpDownloadSize)
+	if err != nil { // 暂不处理
+		return
+	}
+
+	// 并行的连接共用 commonBuf, 采样时使用单独的缓冲区, 采样足够后换回 commonBuf
+	buf, sampler := commonBuf, t.sampler
+	if sampler != nil && !sampler.full() {
+		buf = make([]byte, len(commonBuf))
+	} else {
+		sampler = nil
+	}
+
+	var n int
+	for {
+		select {
+		case <-ctx.Done():
 			return
-		}
-		defer conn.Close()
-
-		// 1分钟
-		// 超时会产生错误：io.EOF
-		after := time.After(1 * time.Minute)
-		_, err = conn.Write(bytemessage.Smessagef("DOWNLOAD %d\n", UpDownloadSize))
-		if err != nil { // 暂不处理
-			errChan <- err
+		case <-after:
 			return
-		}
-
-		var n int
-	loop:
-		for {
-			select {
-			case <-ctx.Done():
-				break loop
-			case <-after:
-				break loop
-			default:
-				n, err = conn.Read(commonBuf)
-				speedStat.Add(int64(n))
-				statistic.AddTransferSize(int64(n)) // 增加
-				if err != nil {
-					break loop
-				}
+		default:
+			n, err = conn.Read(buf)
+			if sampler != nil && n > 0 && sampler.write(buf[:n]) {
+				buf, sampler = commonBuf, nil
+			}
+			t.add(int64(n), statistic, speedStat)
+			if err != nil {
+				return
 			}
 		}
-		errChan <- err
-		return
-	})
+	}
 }
 
-func (sch *SpeedtestClientWithHost) Upload(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
-	return sch.upDownload(opt, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
-		conn, err := sch.dialHost()
-		if err != nil {
-			errChan <- err
-			return
-		}
-		defer conn.Close()
-
-		// 1分钟
-		// 超时会产生错误：broken pipe
-		after := time.After(1 * time.Minute)
-		_, err = conn.Write(bytemessage.Smessagef("UPLOAD %d\n", UpDownloadSize))
-		if err != nil { // 暂不处理
-			errChan <- err
+// upload 使用一个连接上传, 直到结束或者出错
+func (t *hostTransfer) upload(ctx context.Context, statistic *Statistic, speedStat *speeds.Speeds) (err error) {
+	conn, err := t.dial(statistic)
+	if err != nil {
+		return
+	}
+	defer t.close(conn, statistic)
+
+	// 1分钟
+	// 超时会产生错误：broken pipe
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+	err = conn.writeCommand("UPLOAD %d\n", UpDownloadSize)
+	if err != nil { // 暂不处理
+		return
+	}
+
+	// 经过 WebSocket 等握手的连接不能直接写入底层的连接
+	p := t.payload
+	if p.file != nil && conn.Conn == conn.raw {
+		err = sendPayload(ctx, conn.raw, p, func(n int64) {
+			atomic.StoreInt32(&t.zeroCopy, 1)
+			t.add(n, statistic, speedStat)
+		})
+		if err != errZeroCopyUnsupported {
 			return
 		}
+	}
 
-		var n int
-	loop:
-		for {
-			select {
-			case <-ctx.Done():
-				break loop
-			case <-after:
-				break loop
-			default:
-				n, err = conn.Write(commonBuf)
-				speedStat.Add(int64(n))
-				statistic.AddTransferSize(int64(n)) // 增加
-				if err != nil {
-					break loop
-				}
+	var (
+		n   int
+		off int
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			n, err = conn.Write(p.next(&off))
+			t.add(int64(n), statistic, speedStat)
+			if err != nil {
+				return
 			}
 		}
-		errChan <- err
+	}
+}
+
+// fillRes 记录该服务器的连接信息等结果
+func (t *hostTransfer) fillRes(res *UpDownloadRes) {
+	res.ConnInfo = t.sch.ConnInfo()
+	res.TCPOptions = t.tcpOpts
+	res.MPTCP = t.mptcp.stats()
+	res.ZeroCopy = atomic.LoadInt32(&t.zeroCopy) == 1
+	if t.sampler != nil {
+		res.DownloadSample = t.sampler.result()
+	}
+}
+
+func (sch *SpeedtestClientWithHost) Download(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
+	t := sch.newTransfer(opt, nil)
+	t.sampler = opt.downloadSampler()
+	res, err = upDownload(opt, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
+		errChan <- t.download(ctx, commonBuf, statistic, speedStat)
+	})
+	if res != nil {
+		t.fillRes(res)
+	}
+	return
+}
+
+func (sch *SpeedtestClientWithHost) Upload(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error) {
+	p, err := opt.newPayload()
+	if err != nil {
 		return
+	}
+	defer p.Close()
+
+	t := sch.newTransfer(opt, p)
+	res, err = upDownload(opt, callback, func(ctx context.Context, commonBuf []byte, errChan chan<- error, statistic *Statistic, speedStat *speeds.Speeds) {
+		errChan <- t.upload(ctx, statistic, speedStat)
 	})
+	if res != nil {
+		t.fillRes(res)
+		res.Payload = opt.payload().String()
+	}
+	return
 }
@@ -0,0 +1,63 @@
+package speedtestclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type (
+	// SocketOptions 套接字选项, 在连接建立之前设置
+	SocketOptions struct {
+		BindDevice string // 绑定网卡, SO_BINDTODEVICE, 仅 Linux
+		Mark       int    // 防火墙标记, SO_MARK, 仅 Linux
+		// DSCP 差分服务代码点, 0 到 63, 设置 IPv4 的 IP_TOS 或 IPv6 的 IPV6_TCLASS, 为 nil 则不设置, 仅 Linux
+		DSCP *int
+	}
+
+	dialControlFunc func(network, address string, c syscall.RawConn) error
+)
+
+// dscpClasses DSCP 的名称, RFC 4594
+var dscpClasses = map[string]int{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46, "VA": 44, "LE": 1,
+}
+
+// ParseDSCP 解析 DSCP, 可以是 0 到 63 的数字, 或者名称, 如 EF, AF41, CS1
+func ParseDSCP(s string) (dscp int, err error) {
+	if v, ok := dscpClasses[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	dscp, err = strconv.Atoi(s)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q, expect 0-63 or a class name, e.g. EF, AF41, CS1", s)
+	}
+	return dscp, nil
+}
+
+// SetSocketOptions 设置测速连接的套接字选项, 不影响获取配置的连接
+func (sch *SpeedtestClientWithHost) SetSocketOptions(opts *SocketOptions) {
+	sch.sockOpts = opts
+}
+
+// control 返回 net.Dialer 的 Control, opts 为 nil 时返回 nil
+func (opts *SocketOptions) control() dialControlFunc {
+	if opts == nil {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) (err error) {
+		cerr := c.Control(func(fd uintptr) {
+			err = opts.apply(network, fd)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package speedtestclient
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+func (opts *SocketOptions) apply(network string, fd uintptr) (err error) {
+	if opts.BindDevice != "" {
+		err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, opts.BindDevice)
+		if err != nil {
+			return os.NewSyscallError("setsockopt SO_BINDTODEVICE", err)
+		}
+	}
+	if opts.Mark != 0 {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, opts.Mark)
+		if err != nil {
+			return os.NewSyscallError("setsockopt SO_MARK", err)
+		}
+	}
+	if opts.DSCP != nil {
+		// DSCP 为 TOS 或 traffic class 的高 6 位
+		if strings.HasSuffix(network, "6") {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, *opts.DSCP<<2)
+			if err != nil {
+				return os.NewSyscallError("setsockopt IPV6_TCLASS", err)
+			}
+		} else {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, *opts.DSCP<<2)
+			if err != nil {
+				return os.NewSyscallError("setsockopt IP_TOS", err)
+			}
+		}
+	}
+	return nil
+}
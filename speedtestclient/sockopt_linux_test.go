@@ -0,0 +1,64 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"net"
+	"testing"
+)
+
+func TestParseDSCP(t *testing.T) {
+	for s, expected := range map[string]int{"0": 0, "46": 46, "ef": 46, "AF41": 34, "cs1": 8} {
+		dscp, err := speedtestclient.ParseDSCP(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dscp != expected {
+			t.Fatalf("%s: expected %d, got %d\n", s, expected, dscp)
+		}
+	}
+	for _, s := range []string{"64", "-1", "AF5"} {
+		_, err := speedtestclient.ParseDSCP(s)
+		if err == nil {
+			t.Fatalf("%s: expected error\n", s)
+		}
+	}
+}
+
+func TestDSCP(t *testing.T) {
+	for network, address := range map[string]string{"tcp4": "127.0.0.1:0", "tcp6": "[::1]:0"} {
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			t.Logf("%s: %s, skip\n", network, err)
+			continue
+		}
+		addr, closeFn := serveFakeListener(ln)
+
+		withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+		withHost.SetNetwork(network)
+		dscp := 46
+		withHost.SetSocketOptions(&speedtestclient.SocketOptions{
+			DSCP: &dscp,
+		})
+		_, err = withHost.HI()
+		closeFn()
+		if err != nil {
+			t.Fatalf("%s: %s\n", network, err)
+		}
+	}
+}
+
+func TestDSCPZero(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+
+	// 0 (CS0) 也可以明确设置, 覆盖系统的默认值
+	dscp := 0
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+	withHost.SetSocketOptions(&speedtestclient.SocketOptions{
+		DSCP: &dscp,
+	})
+	_, err := withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
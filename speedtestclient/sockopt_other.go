@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package speedtestclient
+
+func (opts *SocketOptions) apply(network string, fd uintptr) (err error) {
+	if opts.BindDevice != "" || opts.Mark != 0 || opts.DSCP != nil {
+		return ErrSocketOptionUnsupported
+	}
+	return nil
+}
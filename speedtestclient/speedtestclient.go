@@ -3,7 +3,10 @@ package speedtestclient
 import (
 	"fmt"
 	"github.com/iikira/iikira-go-utils/requester"
+	"net"
+	"net/http"
 	"net/url"
+	"time"
 )
 
 const (
@@ -12,7 +15,18 @@ const (
 
 type (
 	SpeedtestClient struct {
-		hc *requester.HTTPClient
+		hc          *requester.HTTPClient
+		hcTransport *http.Transport // requester 创建的 transport, 代理设置保存在这里
+		httpTest    *http.Transport // HTTP 测速使用的 transport, 与获取配置的连接分开
+		localAddr   *net.TCPAddr
+		sockOpts    *SocketOptions
+	}
+
+	// Tester 测速, 由 SpeedtestClientWithHost 和 SpeedtestClientWithURL 实现
+	Tester interface {
+		Ping(times int, sleep time.Duration, callback PingCallback) (res *PingRes, err error)
+		Download(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error)
+		Upload(opt *UpDownloadOption, callback UpDownloadCallback) (res *UpDownloadRes, err error)
 	}
 )
 
@@ -23,6 +37,7 @@ func NewSpeedtestClient() *SpeedtestClient {
 func (sc *SpeedtestClient) lazyInit() {
 	if sc.hc == nil {
 		sc.hc = requester.NewHTTPClient()
+		sc.updateTransport()
 	}
 }
 
@@ -32,6 +47,56 @@ func (sc *SpeedtestClient) SetProxy(proxyAddr string) {
 	sc.hc.SetProxy(proxyAddr)
 }
 
+// SetLocalAddr 设置本地地址, 用于获取配置和测速的连接
+func (sc *SpeedtestClient) SetLocalAddr(localAddr *net.TCPAddr) {
+	sc.localAddr = localAddr
+	sc.updateTransport()
+}
+
+// SetSocketOptions 设置套接字选项, 用于获取配置和测速的连接
+func (sc *SpeedtestClient) SetSocketOptions(opts *SocketOptions) {
+	sc.sockOpts = opts
+	sc.updateTransport()
+}
+
+// updateTransport 使获取配置的 http 请求和 HTTP 测速, 应用本地地址和套接字选项
+func (sc *SpeedtestClient) updateTransport() {
+	sc.lazyInit()
+	if sc.hcTransport == nil {
+		sc.hc.SetKeepAlive(true) // 初始化 transport, 与默认值相同
+		t, ok := sc.hc.Transport.(*http.Transport)
+		if !ok {
+			return
+		}
+		sc.hcTransport = t
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: sc.localAddr,
+		Control:   sc.sockOpts.control(),
+	}
+	sc.hc.Transport = sc.newTransport(dialer)
+	sc.httpTest = sc.newTransport(dialer)
+}
+
+// newTransport 使用 dialer 创建 transport, 其余设置与 requester 的相同
+func (sc *SpeedtestClient) newTransport(dialer *net.Dialer) *http.Transport {
+	return &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return sc.hcTransport.Proxy(req)
+		},
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       sc.hcTransport.TLSClientConfig,
+		TLSHandshakeTimeout:   sc.hcTransport.TLSHandshakeTimeout,
+		MaxIdleConns:          sc.hcTransport.MaxIdleConns,
+		IdleConnTimeout:       sc.hcTransport.IdleConnTimeout,
+		ResponseHeaderTimeout: sc.hcTransport.ResponseHeaderTimeout,
+		ExpectContinueTimeout: sc.hcTransport.ExpectContinueTimeout,
+	}
+}
+
 func (sc *SpeedtestClient) genURL(path string, param map[string]interface{}) *url.URL {
 	u := url.URL{
 		Scheme: "https",
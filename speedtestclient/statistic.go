@@ -1,6 +1,7 @@
 package speedtestclient
 
 import (
+	"github.com/iikira/iikira-go-utils/requester/rio/speeds"
 	"github.com/iikira/iikira-go-utils/utils/expires"
 	"sync/atomic"
 	"time"
@@ -15,6 +16,16 @@ type (
 		speedPerSeconds []int64   // 用来计算平均速度的
 		startTime       time.Time // 启动时间
 		deadline        time.Time // 截止时间
+		tcpInfo         *tcpInfoRecorder
+		hosts           []*HostStatistic // 多服务器测速时各服务器的统计
+	}
+
+	// HostStatistic 多服务器测速时单个服务器的统计
+	HostStatistic struct {
+		Statistic
+		Host      string
+		speedStat speeds.Speeds
+		streams   int // 正在使用的连接数
 	}
 )
 
@@ -57,3 +68,28 @@ func (s *Statistic) StartTimer() {
 func (s *Statistic) AddTransferSize(size int64) int64 {
 	return atomic.AddInt64(&s.transferSize, size)
 }
+
+// Hosts 多服务器测速时各服务器的统计, 否则为 nil
+func (s *Statistic) Hosts() []*HostStatistic {
+	return s.hosts
+}
+
+// newHostStatistic 创建服务器的统计
+func newHostStatistic(host string) *HostStatistic {
+	return &HostStatistic{
+		Statistic: Statistic{
+			totalSize:       UpDownloadSize,
+			speedPerSeconds: make([]int64, 0, 32),
+			tcpInfo:         newTCPInfoRecorder(),
+		},
+		Host: host,
+	}
+}
+
+// sample 记录该服务器的当前速度
+func (h *HostStatistic) sample() {
+	speed := h.speedStat.GetSpeeds()
+	h.AppendSpeedPerSecond(speed)
+	h.tcpInfo.sample()
+	h.speedPerSecond = speed
+}
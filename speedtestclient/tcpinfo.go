@@ -0,0 +1,166 @@
+package speedtestclient
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type (
+	// TCPInfo TCP_INFO 中的部分指标, 仅 Linux
+	TCPInfo struct {
+		RTT          time.Duration // 平滑 RTT
+		RTTVar       time.Duration // RTT 方差
+		Retransmits  uint32        // 重传的分段总数
+		SndCwnd      uint32        // 拥塞窗口, 单位为分段
+		SndMSS       uint32        // 发送的 MSS
+		DeliveryRate int64         // 交付速率, bytes/s
+		PacingRate   int64         // 发送速率, bytes/s
+		Congestion   string        // 使用中的拥塞控制算法
+	}
+
+	// TCPStats 下载或上传期间, 各连接的 TCP_INFO
+	TCPStats struct {
+		Streams []*TCPInfo // 每个连接最后一次采样的结果, 包括已关闭和重新建立的连接
+		// Aggregate 最后一次采样时同时打开的连接的汇总, RTT, RTTVar 和 MSS 取平均,
+		// SndCwnd, DeliveryRate 和 PacingRate 求和, Retransmits 为所有连接的重传总数
+		Aggregate *TCPInfo
+	}
+
+	// tcpInfoRecorder 记录连接的 TCP_INFO
+	tcpInfoRecorder struct {
+		mu      sync.Mutex
+		active  map[*hostConn]*TCPInfo
+		streams []*TCPInfo
+		// concurrent 最后一次采样时打开的连接的汇总
+		concurrent *TCPInfo
+	}
+)
+
+// GetTCPInfo 读取连接的 TCP_INFO, conn 需要实现 syscall.Conn, 如 *net.TCPConn
+func GetTCPInfo(conn net.Conn) (info *TCPInfo, err error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrTCPInfoUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		info, serr = getTCPInfo(fd)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, serr
+}
+
+func newTCPInfoRecorder() *tcpInfoRecorder {
+	return &tcpInfoRecorder{
+		active: map[*hostConn]*TCPInfo{},
+	}
+}
+
+// add 开始记录连接
+func (r *tcpInfoRecorder) add(conn *hostConn) {
+	info, err := GetTCPInfo(conn.raw)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active[conn] = info
+	r.streams = append(r.streams, info)
+	r.aggregateActive()
+}
+
+// remove 连接关闭前, 最后一次采样
+func (r *tcpInfoRecorder) remove(conn *hostConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.active[conn]
+	if !ok {
+		return
+	}
+	delete(r.active, conn)
+	if latest, err := GetTCPInfo(conn.raw); err == nil {
+		*info = *latest
+	}
+}
+
+// sample 对所有活动的连接采样
+func (r *tcpInfoRecorder) sample() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for conn, info := range r.active {
+		if latest, err := GetTCPInfo(conn.raw); err == nil {
+			*info = *latest
+		}
+	}
+	r.aggregateActive()
+}
+
+// aggregateActive 汇总打开的连接, 没有打开的连接时保留上一次的汇总
+func (r *tcpInfoRecorder) aggregateActive() {
+	if len(r.active) == 0 {
+		return
+	}
+	infos := make([]*TCPInfo, 0, len(r.active))
+	for _, info := range r.active {
+		infos = append(infos, info)
+	}
+	r.concurrent = aggregateTCPInfo(infos)
+}
+
+// stats 返回采样结果, 没有结果则返回 nil
+func (r *tcpInfoRecorder) stats() *TCPStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.streams) == 0 {
+		return nil
+	}
+
+	stats := &TCPStats{
+		Streams: make([]*TCPInfo, 0, len(r.streams)),
+	}
+	agg := *r.concurrent
+	agg.Retransmits = 0
+	for _, info := range r.streams {
+		stream := *info
+		stats.Streams = append(stats.Streams, &stream)
+		agg.Retransmits += info.Retransmits
+	}
+	stats.Aggregate = &agg
+	return stats
+}
+
+// aggregateTCPInfo 汇总同时打开的连接, RTT, RTTVar 和 MSS 取平均, 其余求和
+func aggregateTCPInfo(infos []*TCPInfo) *TCPInfo {
+	agg := &TCPInfo{}
+	for _, info := range infos {
+		agg.RTT += info.RTT
+		agg.RTTVar += info.RTTVar
+		agg.Retransmits += info.Retransmits
+		agg.SndCwnd += info.SndCwnd
+		agg.SndMSS += info.SndMSS
+		agg.DeliveryRate += info.DeliveryRate
+		agg.PacingRate += info.PacingRate
+	}
+	// 各连接的拥塞控制算法相同时才记录
+	agg.Congestion = infos[0].Congestion
+	for _, info := range infos[1:] {
+		if info.Congestion != agg.Congestion {
+			agg.Congestion = ""
+			break
+		}
+	}
+	n := len(infos)
+	agg.RTT /= time.Duration(n)
+	agg.RTTVar /= time.Duration(n)
+	agg.SndMSS /= uint32(n)
+	return agg
+}
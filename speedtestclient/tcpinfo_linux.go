@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package speedtestclient
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+type (
+	// rawTCPInfo linux 的 struct tcp_info, 到 tcpi_delivery_rate 为止
+	rawTCPInfo struct {
+		state         uint8
+		caState       uint8
+		retransmits   uint8
+		probes        uint8
+		backoff       uint8
+		options       uint8
+		wscale        uint8
+		appLimited    uint8
+		rto           uint32
+		ato           uint32
+		sndMSS        uint32
+		rcvMSS        uint32
+		unacked       uint32
+		sacked        uint32
+		lost          uint32
+		retrans       uint32
+		fackets       uint32
+		lastDataSent  uint32
+		lastAckSent   uint32
+		lastDataRecv  uint32
+		lastAckRecv   uint32
+		pmtu          uint32
+		rcvSsthresh   uint32
+		rtt           uint32 // 微秒
+		rttvar        uint32 // 微秒
+		sndSsthresh   uint32
+		sndCwnd       uint32
+		advmss        uint32
+		reordering    uint32
+		rcvRTT        uint32
+		rcvSpace      uint32
+		totalRetrans  uint32
+		pacingRate    uint64
+		maxPacingRate uint64
+		bytesAcked    uint64
+		bytesReceived uint64
+		segsOut       uint32
+		segsIn        uint32
+		notsentBytes  uint32
+		minRTT        uint32
+		dataSegsIn    uint32
+		dataSegsOut   uint32
+		deliveryRate  uint64
+	}
+)
+
+func getTCPInfo(fd uintptr) (info *TCPInfo, err error) {
+	var (
+		raw    rawTCPInfo
+		rawLen = uint32(unsafe.Sizeof(raw))
+	)
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO, uintptr(unsafe.Pointer(&raw)), uintptr(unsafe.Pointer(&rawLen)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("getsockopt TCP_INFO", errno)
+	}
+
+	// 未限制发送速率
+	if raw.pacingRate == ^uint64(0) {
+		raw.pacingRate = 0
+	}
+
+	// 旧的内核没有 pacing_rate, delivery_rate 等字段, 保持为零值
+	info = &TCPInfo{
+		RTT:          time.Duration(raw.rtt) * time.Microsecond,
+		RTTVar:       time.Duration(raw.rttvar) * time.Microsecond,
+		Retransmits:  raw.totalRetrans,
+		SndCwnd:      raw.sndCwnd,
+		SndMSS:       raw.sndMSS,
+		DeliveryRate: int64(raw.deliveryRate),
+		PacingRate:   int64(raw.pacingRate),
+	}
+	info.Congestion, _ = getCongestion(fd)
+	return info, nil
+}
+
+// getCongestion 读取 TCP_CONGESTION
+func getCongestion(fd uintptr) (string, error) {
+	var (
+		buf    [16]byte // TCP_CA_NAME_MAX
+		bufLen = uint32(len(buf))
+	)
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&bufLen)), 0)
+	if errno != 0 {
+		return "", os.NewSyscallError("getsockopt TCP_CONGESTION", errno)
+	}
+	name := buf[:bufLen]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name), nil
+}
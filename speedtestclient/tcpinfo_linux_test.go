@@ -0,0 +1,32 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"net"
+	"testing"
+)
+
+func TestGetTCPInfo(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	info, err := speedtestclient.GetTCPInfo(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%#v\n", info)
+	if info.SndMSS == 0 || info.SndCwnd == 0 {
+		t.Fatalf("unexpected tcp info: %#v\n", info)
+	}
+
+	_, err = speedtestclient.GetTCPInfo(&net.IPConn{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package speedtestclient
+
+func getTCPInfo(fd uintptr) (info *TCPInfo, err error) {
+	return nil, ErrTCPInfoUnsupported
+}
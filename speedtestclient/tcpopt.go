@@ -0,0 +1,100 @@
+package speedtestclient
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type (
+	// TCPOptions 测速连接的 TCP 选项, 用于下载和上传
+	TCPOptions struct {
+		Congestion string `json:"congestion,omitempty"`  // 拥塞控制算法, TCP_CONGESTION, 如 bbr, cubic, 仅 Linux
+		RecvBuffer int    `json:"recv_buffer,omitempty"` // 接收缓冲区, SO_RCVBUF
+		SendBuffer int    `json:"send_buffer,omitempty"` // 发送缓冲区, SO_SNDBUF
+		MSS        int    `json:"mss,omitempty"`         // 最大分段大小, TCP_MAXSEG, 仅 Linux
+		NoDelay    *bool  `json:"nodelay,omitempty"`     // TCP_NODELAY, 为 nil 则使用默认值(开启)
+	}
+)
+
+// IsZero 是否没有设置任何选项
+func (opts *TCPOptions) IsZero() bool {
+	return opts == nil || *opts == TCPOptions{}
+}
+
+func (opts *TCPOptions) String() string {
+	if opts.IsZero() {
+		return "default"
+	}
+	var s []string
+	if opts.Congestion != "" {
+		s = append(s, "congestion="+opts.Congestion)
+	}
+	if opts.RecvBuffer != 0 {
+		s = append(s, "rcvbuf="+strconv.Itoa(opts.RecvBuffer))
+	}
+	if opts.SendBuffer != 0 {
+		s = append(s, "sndbuf="+strconv.Itoa(opts.SendBuffer))
+	}
+	if opts.MSS != 0 {
+		s = append(s, "mss="+strconv.Itoa(opts.MSS))
+	}
+	if opts.NoDelay != nil {
+		s = append(s, "nodelay="+strconv.FormatBool(*opts.NoDelay))
+	}
+	return strings.Join(s, ",")
+}
+
+// control 在 next 之后设置 TCP 选项, 选项需要在连接建立之前设置,
+// 如缓冲区大小会影响窗口扩大因子
+func (opts *TCPOptions) control(next dialControlFunc) dialControlFunc {
+	if opts.IsZero() {
+		return next
+	}
+	return func(network, address string, c syscall.RawConn) (err error) {
+		if next != nil {
+			err = next(network, address, c)
+			if err != nil {
+				return
+			}
+		}
+		cerr := c.Control(func(fd uintptr) {
+			err = opts.apply(fd)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return
+	}
+}
+
+// applyConn 连接建立之后设置的选项,
+// net 包在连接建立后总是开启 TCP_NODELAY, 因此需要在之后设置
+func (opts *TCPOptions) applyConn(conn net.Conn) (err error) {
+	if opts.IsZero() {
+		return nil
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if !buffersBeforeConnect {
+		if opts.RecvBuffer != 0 {
+			err = tcpConn.SetReadBuffer(opts.RecvBuffer)
+			if err != nil {
+				return
+			}
+		}
+		if opts.SendBuffer != 0 {
+			err = tcpConn.SetWriteBuffer(opts.SendBuffer)
+			if err != nil {
+				return
+			}
+		}
+	}
+	if opts.NoDelay != nil {
+		err = tcpConn.SetNoDelay(*opts.NoDelay)
+	}
+	return
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package speedtestclient
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	// buffersBeforeConnect 在连接建立之前设置缓冲区大小
+	buffersBeforeConnect = true
+)
+
+func (opts *TCPOptions) apply(fd uintptr) (err error) {
+	if opts.Congestion != "" {
+		err = syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, opts.Congestion)
+		if err != nil {
+			return os.NewSyscallError("setsockopt TCP_CONGESTION", err)
+		}
+	}
+	if opts.MSS != 0 {
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, opts.MSS)
+		if err != nil {
+			return os.NewSyscallError("setsockopt TCP_MAXSEG", err)
+		}
+	}
+	if opts.RecvBuffer != 0 {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, opts.RecvBuffer)
+		if err != nil {
+			return os.NewSyscallError("setsockopt SO_RCVBUF", err)
+		}
+	}
+	if opts.SendBuffer != 0 {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, opts.SendBuffer)
+		if err != nil {
+			return os.NewSyscallError("setsockopt SO_SNDBUF", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package speedtestclient_test
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"testing"
+	"time"
+)
+
+func TestTCPOptions(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+
+	noDelay := false
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          300 * time.Millisecond,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+		TCPOptions: speedtestclient.TCPOptions{
+			Congestion: "reno",
+			RecvBuffer: 256 * 1024,
+			SendBuffer: 256 * 1024,
+			NoDelay:    &noDelay,
+		},
+	}
+	res, err := withHost.Download(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TCPOptions == nil || res.TCPOptions.Congestion != "reno" {
+		t.Fatalf("unexpected tcp options: %#v\n", res.TCPOptions)
+	}
+	if res.TCPInfo == nil || res.TCPInfo.Aggregate.Congestion != "reno" {
+		t.Fatalf("unexpected tcp info: %#v\n", res.TCPInfo)
+	}
+
+	opt.TCPOptions = speedtestclient.TCPOptions{
+		Congestion: "no-such-algorithm",
+	}
+	_, err = withHost.Download(opt, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
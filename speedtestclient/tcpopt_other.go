@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package speedtestclient
+
+const (
+	// buffersBeforeConnect 其他平台在连接建立之后设置缓冲区大小
+	buffersBeforeConnect = false
+)
+
+func (opts *TCPOptions) apply(fd uintptr) (err error) {
+	if opts.Congestion != "" || opts.MSS != 0 {
+		return ErrSocketOptionUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package speedtestclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"github.com/iikira/speedtest/speedtestutil/bytemessage"
+	"golang.org/x/net/websocket"
+	"net"
+	"strings"
+)
+
+const (
+	// WebSocketPath WebSocket 服务的路径
+	WebSocketPath = "/ws"
+)
+
+const (
+	// TransportTCP 原始 TCP, 默认
+	TransportTCP Transport = iota
+	// TransportWebSocket WebSocket, ws://host/ws
+	TransportWebSocket
+	// TransportWebSocketTLS 基于 TLS 的 WebSocket, wss://host/ws
+	TransportWebSocketTLS
+)
+
+type (
+	// Transport 测速命令使用的传输方式
+	Transport int
+)
+
+// ParseTransport 解析传输方式, 可选 tcp, ws, wss
+func ParseTransport(s string) (t Transport, err error) {
+	switch strings.ToLower(s) {
+	case "", "tcp":
+		return TransportTCP, nil
+	case "ws", "websocket":
+		return TransportWebSocket, nil
+	case "wss":
+		return TransportWebSocketTLS, nil
+	}
+	return TransportTCP, fmt.Errorf("unknown transport: %s", s)
+}
+
+func (t Transport) String() string {
+	switch t {
+	case TransportTCP:
+		return "tcp"
+	case TransportWebSocket:
+		return "ws"
+	case TransportWebSocketTLS:
+		return "wss"
+	}
+	return fmt.Sprintf("Transport(%d)", int(t))
+}
+
+// SetTransport 设置传输方式
+func (sch *SpeedtestClientWithHost) SetTransport(t Transport) {
+	sch.transport = t
+}
+
+// SetTLSConfig 设置 wss 使用的 TLS 配置, 为 nil 则使用默认的配置,
+// 未设置 ServerName 时使用 sch.Host 的主机名
+func (sch *SpeedtestClientWithHost) SetTLSConfig(config *tls.Config) {
+	sch.tlsConfig = config
+}
+
+// upgradeConn 在已建立的 TCP 连接上, 按照传输方式进行握手
+func (sch *SpeedtestClientWithHost) upgradeConn(conn net.Conn) (upgraded net.Conn, err error) {
+	var scheme string
+	switch sch.transport {
+	case TransportTCP:
+		return conn, nil
+	case TransportWebSocket:
+		scheme = "ws"
+	case TransportWebSocketTLS:
+		scheme = "wss"
+		host, _, splitErr := net.SplitHostPort(sch.Host)
+		if splitErr != nil {
+			host = sch.Host
+		}
+		tlsConfig := &tls.Config{}
+		if sch.tlsConfig != nil {
+			tlsConfig = sch.tlsConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		err = tlsConn.Handshake()
+		if err != nil {
+			return
+		}
+		conn = tlsConn
+	default:
+		return nil, fmt.Errorf("unknown transport: %s", sch.transport)
+	}
+
+	config, err := websocket.NewConfig(scheme+"://"+sch.Host+WebSocketPath, "http://"+sch.Host+"/")
+	if err != nil {
+		return
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		return
+	}
+	ws.PayloadType = websocket.BinaryFrame // 上传的数据
+	return ws, nil
+}
+
+// writeCommand 发送命令, WebSocket 的命令以文本帧发送, 不带换行
+func (conn *hostConn) writeCommand(format string, a ...interface{}) (err error) {
+	msg := bytemessage.Smessagef(format, a...)
+	ws, ok := conn.Conn.(*websocket.Conn)
+	if !ok {
+		_, err = conn.Write(msg)
+		return
+	}
+
+	ws.PayloadType = websocket.TextFrame
+	_, err = ws.Write(bytes.TrimSuffix(msg, []byte{'\n'}))
+	ws.PayloadType = websocket.BinaryFrame
+	return
+}
@@ -0,0 +1,141 @@
+package speedtestclient_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/iikira/speedtest/speedtestclient"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebSocketTransport(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var msg string
+		for websocket.Message.Receive(ws, &msg) == nil {
+			switch {
+			case msg == "HI":
+				websocket.Message.Send(ws, "HELLO 2.9 (2.9.3) 2020-11-10.1948.a0bb7f8\n")
+			case strings.HasPrefix(msg, "PING "):
+				websocket.Message.Send(ws, "PONG "+strings.TrimPrefix(msg, "PING ")+"\n")
+			}
+		}
+	}))
+	defer server.Close()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(strings.TrimPrefix(server.URL, "http://"))
+	withHost.SetTransport(speedtestclient.TransportWebSocket)
+
+	hiRes, err := withHost.HI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hiRes.Info.Version != "2.9" {
+		t.Fatalf("unexpected info: %#v\n", hiRes.Info)
+	}
+
+	pingRes, err := withHost.Ping(2, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%#v\n", pingRes)
+}
+
+// fakeWebSocket 模拟 WebSocket 服务端, 处理 HI, DOWNLOAD, UPLOAD.
+// DOWNLOAD 只发送 downloadSize 字节, 然后等待 done 关闭; UPLOAD 统计收到的数据量
+type fakeWebSocket struct {
+	downloadSize int
+	done         chan struct{}
+	uploaded     int64
+	wg           sync.WaitGroup // 正在处理的连接
+}
+
+func (f *fakeWebSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.wg.Add(1)
+	defer f.wg.Done()
+	websocket.Handler(f.serve).ServeHTTP(w, r)
+}
+
+func (f *fakeWebSocket) serve(ws *websocket.Conn) {
+	var msg string
+	for websocket.Message.Receive(ws, &msg) == nil {
+		switch {
+		case msg == "HI":
+			websocket.Message.Send(ws, "HELLO 2.9 (2.9.3) 2020-11-10.1948.a0bb7f8\n")
+		case strings.HasPrefix(msg, "DOWNLOAD "):
+			websocket.Message.Send(ws, make([]byte, f.downloadSize))
+			<-f.done
+			return
+		case strings.HasPrefix(msg, "UPLOAD "):
+			var data []byte
+			for websocket.Message.Receive(ws, &data) == nil {
+				atomic.AddInt64(&f.uploaded, int64(len(data)))
+			}
+			return
+		}
+	}
+}
+
+func TestWebSocketUpDownload(t *testing.T) {
+	for _, c := range []struct {
+		transport speedtestclient.Transport
+		newServer func(http.Handler) *httptest.Server
+	}{
+		{speedtestclient.TransportWebSocket, httptest.NewServer},
+		{speedtestclient.TransportWebSocketTLS, httptest.NewTLSServer},
+	} {
+		t.Run(c.transport.String(), func(t *testing.T) {
+			fake := &fakeWebSocket{
+				downloadSize: 1 << 20,
+				done:         make(chan struct{}),
+			}
+			server := c.newServer(fake)
+			defer server.Close()
+
+			withHost := speedtestclient.NewSpeedtestClient().WithHost(server.Listener.Addr().String())
+			withHost.SetTransport(c.transport)
+			if server.TLS != nil {
+				roots := x509.NewCertPool()
+				roots.AddCert(server.Certificate())
+				withHost.SetTLSConfig(&tls.Config{RootCAs: roots})
+			}
+
+			opt := &speedtestclient.UpDownloadOption{
+				Timeout:          300 * time.Millisecond,
+				Parallel:         2,
+				CallbackInterval: 100 * time.Millisecond,
+				ZeroCopy:         true,
+			}
+			var stat *speedtestclient.Statistic
+			callback := func(s *speedtestclient.Statistic) { stat = s }
+
+			// 每个连接只下载 downloadSize 字节
+			_, err := withHost.Download(opt, callback)
+			close(fake.done)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := stat.TransferSize(); n != int64(opt.Parallel*fake.downloadSize) {
+				t.Fatalf("downloaded %d bytes, want %d\n", n, opt.Parallel*fake.downloadSize)
+			}
+
+			// 经过 WebSocket 握手的连接不使用零拷贝, 服务端收到的数据量与统计的一致
+			res, err := withHost.Upload(opt, callback)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fake.wg.Wait()
+			if res.ZeroCopy {
+				t.Fatalf("zero copy should not be used over %s\n", c.transport)
+			}
+			if n := stat.TransferSize(); n == 0 || n != atomic.LoadInt64(&fake.uploaded) {
+				t.Fatalf("uploaded %d bytes, server received %d\n", n, atomic.LoadInt64(&fake.uploaded))
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package speedtestclient
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+)
+
+const (
+	zeroCopySupported = true
+)
+
+// sendPayload 使用 sendfile 将 p.file 循环发送到 conn, 直到 ctx 结束,
+// 每次发送不超过 p.chunk, add 记录发送的数据量
+func sendPayload(ctx context.Context, conn net.Conn, p *payload, add func(n int64)) (err error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errZeroCopyUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	var (
+		infd   = int(p.file.Fd())
+		offset int64
+		n      int
+		serr   error
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if offset >= p.size {
+			offset = 0
+		}
+		count := p.size - offset
+		if count > int64(p.chunk) {
+			count = int64(p.chunk)
+		}
+
+		// offset 由 sendfile 更新, 各连接互不影响
+		err = rc.Write(func(fd uintptr) bool {
+			n, serr = syscall.Sendfile(int(fd), infd, &offset, int(count))
+			return serr != syscall.EAGAIN
+		})
+		if n > 0 {
+			add(int64(n))
+		}
+		if err != nil {
+			return
+		}
+		if serr != nil {
+			return os.NewSyscallError("sendfile", serr)
+		}
+	}
+}
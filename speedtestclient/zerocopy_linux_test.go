@@ -0,0 +1,146 @@
+package speedtestclient_test
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	// fakeServerEnv 设置时 TestFakeServerProcess 作为独立进程运行模拟服务端
+	fakeServerEnv = "SPEEDTEST_FAKE_SERVER_PROCESS"
+)
+
+func TestZeroCopyUpload(t *testing.T) {
+	addr, closeFn := listenFake(t)
+	defer closeFn()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          300 * time.Millisecond,
+		Parallel:         2,
+		CallbackInterval: 100 * time.Millisecond,
+		ZeroCopy:         true,
+	}
+	res, err := withHost.Upload(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.ZeroCopy || res.AverageSpeed == 0 {
+		t.Fatalf("unexpected result: %#v\n", res)
+	}
+
+	// net.Pipe 不支持零拷贝, 使用 Write
+	res, err = newPipeHost().Upload(opt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ZeroCopy || res.AverageSpeed == 0 {
+		t.Fatalf("unexpected result: %#v\n", res)
+	}
+}
+
+// TestFakeServerProcess 由 listenFakeProcess 启动, 输出地址后运行模拟服务端直到被结束
+func TestFakeServerProcess(t *testing.T) {
+	if os.Getenv(fakeServerEnv) == "" {
+		t.Skip("run by listenFakeProcess")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveFakeListener(ln)
+	fmt.Println(ln.Addr())
+	select {}
+}
+
+// listenFakeProcess 在子进程中运行模拟服务端, 使其 CPU 时间不计入当前进程
+func listenFakeProcess(b *testing.B) (addr string, closeFn func()) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFakeServerProcess$")
+	cmd.Env = append(os.Environ(), fakeServerEnv+"=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		b.Fatal(err)
+	}
+	closeFn = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	addr, err = bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		closeFn()
+		b.Fatal(err)
+	}
+	return strings.TrimSpace(addr), closeFn
+}
+
+// benchmarkUpload 通过回环地址上传, 报告吞吐量和每 CPU 秒的吞吐量,
+// 模拟服务端运行在子进程中, CPU 时间只包括客户端
+func benchmarkUpload(b *testing.B, bufSize int, zeroCopy bool) {
+	addr, closeFn := listenFakeProcess(b)
+	defer closeFn()
+
+	withHost := speedtestclient.NewSpeedtestClient().WithHost(addr)
+
+	opt := &speedtestclient.UpDownloadOption{
+		Timeout:          500 * time.Millisecond,
+		Parallel:         1,
+		CallbackInterval: 100 * time.Millisecond,
+		BufferSize:       bufSize,
+		ZeroCopy:         zeroCopy,
+	}
+
+	var (
+		total   int64
+		elapsed time.Duration
+		before  = cpuTime(b)
+	)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, err := withHost.Upload(opt, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += res.AverageSpeed * int64(res.TimeElapsed) / int64(time.Second)
+		elapsed += res.TimeElapsed
+	}
+	b.StopTimer()
+	cpu := cpuTime(b) - before
+
+	b.ReportMetric(float64(total)/elapsed.Seconds()/1e6, "MB/s")
+	b.ReportMetric(float64(total)/cpu.Seconds()/1e6, "MB/cpu-s")
+}
+
+// cpuTime 当前进程使用的 CPU 时间, 不包括子进程
+func cpuTime(b *testing.B) time.Duration {
+	var ru syscall.Rusage
+	err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func BenchmarkUploadWrite2K(b *testing.B) {
+	benchmarkUpload(b, 2048, false)
+}
+
+func BenchmarkUploadWrite(b *testing.B) {
+	benchmarkUpload(b, 0, false)
+}
+
+func BenchmarkUploadZeroCopy(b *testing.B) {
+	benchmarkUpload(b, 0, true)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package speedtestclient
+
+import (
+	"context"
+	"net"
+)
+
+const (
+	zeroCopySupported = false
+)
+
+func sendPayload(ctx context.Context, conn net.Conn, p *payload, add func(n int64)) (err error) {
+	return errZeroCopyUnsupported
+}
@@ -3,13 +3,62 @@ package interfaceutil
 import (
 	"errors"
 	"net"
-	"os"
+	"strings"
+)
+
+const (
+	// FamilyAny 任意地址族
+	FamilyAny Family = iota
+	// FamilyIPv4 IPv4
+	FamilyIPv4
+	// FamilyIPv6 IPv6
+	FamilyIPv6
+)
+
+const (
+	// ScopeHost 回环地址
+	ScopeHost Scope = 1 << iota
+	// ScopeLink 链路本地地址
+	ScopeLink
+	// ScopeGlobal 其他单播地址, 包括私有地址
+	ScopeGlobal
+
+	// ScopeAll 所有范围
+	ScopeAll = ScopeHost | ScopeLink | ScopeGlobal
 )
 
 var (
 	ErrNoSuchAddr = errors.New("no such local addr")
 )
 
+type (
+	// Family 地址族
+	Family int
+
+	// Scope 地址范围, 可以按位组合
+	Scope int
+
+	// Filter 本地地址的过滤条件
+	Filter struct {
+		Family Family
+		Scope  Scope // 为 0 则不过滤
+		// Interfaces 网卡名, 为空则不过滤
+		Interfaces []string
+		// IncludeDown 是否包含未启用的网卡
+		IncludeDown bool
+	}
+
+	// LocalAddr 可用的本地地址
+	LocalAddr struct {
+		Interface string
+		Index     int
+		MTU       int
+		Flags     net.Flags
+		IPNet     *net.IPNet
+		Scope     Scope
+	}
+)
+
 func ParseLocalAddr(interfaceName string) (ipnets []*net.IPNet, err error) {
 	i, err := net.InterfaceByName(interfaceName)
 	if err != nil {
@@ -23,70 +72,204 @@ func ParseLocalAddr(interfaceName string) (ipnets []*net.IPNet, err error) {
 
 	ipnets = make([]*net.IPNet, 0, len(addrs))
 	for _, addr := range addrs {
-		ipnets = append(ipnets, addr.(*net.IPNet))
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ipnets = append(ipnets, ipnet)
 	}
 
 	return ipnets, nil
 }
 
-func GetAvaliableLocalTCPAddr(interfaceName string) (tcpAddr *net.TCPAddr, err error) {
-	ipnets, err := ParseLocalAddr(interfaceName)
+// AddrScope 返回 ip 的地址范围
+func AddrScope(ip net.IP) Scope {
+	switch {
+	case ip.IsLoopback():
+		return ScopeHost
+	case ip.IsLinkLocalUnicast():
+		return ScopeLink
+	}
+	return ScopeGlobal
+}
+
+// AddrFamily 返回 ip 的地址族
+func AddrFamily(ip net.IP) Family {
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	}
+	return "any"
+}
+
+func (s Scope) String() string {
+	var names []string
+	if s&ScopeHost != 0 {
+		names = append(names, "host")
+	}
+	if s&ScopeLink != 0 {
+		names = append(names, "link")
+	}
+	if s&ScopeGlobal != 0 {
+		names = append(names, "global")
+	}
+	return strings.Join(names, "|")
+}
+
+// Match 判断 ip 是否满足过滤条件, 不检查网卡
+func (filter *Filter) Match(ip net.IP) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.Family != FamilyAny && AddrFamily(ip) != filter.Family {
+		return false
+	}
+	if filter.Scope != 0 && AddrScope(ip)&filter.Scope == 0 {
+		return false
+	}
+	return true
+}
+
+func (filter *Filter) matchInterface(iface *net.Interface) bool {
+	if filter == nil {
+		return iface.Flags&net.FlagUp != 0
+	}
+	if !filter.IncludeDown && iface.Flags&net.FlagUp == 0 {
+		return false
+	}
+	if len(filter.Interfaces) == 0 {
+		return true
+	}
+	for _, name := range filter.Interfaces {
+		if name == iface.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// ListLocalAddrs 列出满足过滤条件, 且可以绑定的本地地址
+func ListLocalAddrs(filter *Filter) (localAddrs []*LocalAddr, err error) {
+	ifaces, err := net.Interfaces()
 	if err != nil {
 		return
 	}
-	for _, ipnet := range ipnets {
-		pass, _ := CheckLocalTCPAddr(&net.TCPAddr{IP: ipnet.IP})
-		if pass {
-			tcpAddr = &net.TCPAddr{
-				IP: ipnet.IP,
+
+	for k := range ifaces {
+		iface := &ifaces[k]
+		if !filter.matchInterface(iface) {
+			continue
+		}
+
+		ipnets, err := ParseLocalAddr(iface.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ipnet := range ipnets {
+			if !filter.Match(ipnet.IP) {
+				continue
+			}
+
+			localAddr := &LocalAddr{
+				Interface: iface.Name,
+				Index:     iface.Index,
+				MTU:       iface.MTU,
+				Flags:     iface.Flags,
+				IPNet:     ipnet,
+				Scope:     AddrScope(ipnet.IP),
+			}
+			pass, _ := CheckLocalTCPAddr(localAddr.TCPAddr())
+			if !pass {
+				continue
 			}
-			return
+			localAddrs = append(localAddrs, localAddr)
+		}
+	}
+	return localAddrs, nil
+}
+
+// TCPAddr 返回用于绑定的地址, 链路本地的 IPv6 地址带有 zone
+func (la *LocalAddr) TCPAddr() *net.TCPAddr {
+	tcpAddr := &net.TCPAddr{
+		IP: la.IPNet.IP,
+	}
+	if la.Scope == ScopeLink && AddrFamily(la.IPNet.IP) == FamilyIPv6 {
+		tcpAddr.Zone = la.Interface
+	}
+	return tcpAddr
+}
+
+// Family 地址族
+func (la *LocalAddr) Family() Family {
+	return AddrFamily(la.IPNet.IP)
+}
+
+func (la *LocalAddr) String() string {
+	return la.TCPAddr().IP.String() + " (" + la.Interface + ")"
+}
+
+// GetAvaliableLocalTCPAddr 返回网卡上第一个可以绑定的地址
+func GetAvaliableLocalTCPAddr(interfaceName string) (tcpAddr *net.TCPAddr, err error) {
+	localAddrs, err := ListLocalAddrs(&Filter{
+		Interfaces:  []string{interfaceName},
+		IncludeDown: true,
+	})
+	if err != nil {
+		return
+	}
+	if len(localAddrs) == 0 {
+		// 网卡不存在
+		if _, err = net.InterfaceByName(interfaceName); err != nil {
+			return nil, err
 		}
+		return nil, ErrNoSuchAddr
 	}
-	return nil, ErrNoSuchAddr
+	return localAddrs[0].TCPAddr(), nil
 }
 
+// CheckLocalTCPAddrByString 检查地址是否可以绑定,
+// 链路本地的 IPv6 地址可以带有 zone, 如 fe80::1%eth0
 func CheckLocalTCPAddrByString(addr string) (pass bool, err error) {
 	if addr == "" {
 		return
 	}
 
-	localAddr := &net.TCPAddr{
-		IP: net.ParseIP(addr),
+	localAddr := &net.TCPAddr{}
+	if i := strings.LastIndexByte(addr, '%'); i >= 0 {
+		localAddr.Zone = addr[i+1:]
+		addr = addr[:i]
+	}
+	localAddr.IP = net.ParseIP(addr)
+	if localAddr.IP == nil {
+		return false, &net.AddrError{Err: "invalid IP address", Addr: addr}
 	}
 
 	return CheckLocalTCPAddr(localAddr)
 }
 
+// CheckLocalTCPAddr 检查地址是否可以绑定
 func CheckLocalTCPAddr(tcpAddr *net.TCPAddr) (pass bool, err error) {
-	dialer := &net.Dialer{
-		LocalAddr: tcpAddr,
+	network := "tcp4"
+	if AddrFamily(tcpAddr.IP) == FamilyIPv6 {
+		network = "tcp6"
 	}
 
-	var testAddr string
-	to4 := tcpAddr.IP.To4()
-	if to4 != nil { //ipv4
-		testAddr = "127.0.0.1"
-	} else { // ipv6
-		testAddr = "[::1]"
-	}
-	conn, err := dialer.Dial("tcp", testAddr+":1")
-	if conn != nil {
-		conn.Close()
-	}
+	ln, err := net.ListenTCP(network, &net.TCPAddr{
+		IP:   tcpAddr.IP,
+		Zone: tcpAddr.Zone,
+	})
 	if err != nil {
-		netError, ok := err.(*net.OpError)
-		if !ok {
-			return
-		}
-		syscallError, ok := netError.Err.(*os.SyscallError)
-		if !ok {
-			return
-		}
-		if syscallError.Err.Error() == "connection refused" {
-			return true, nil
-		}
-		return
+		return false, err
 	}
+	ln.Close()
 	return true, nil
 }
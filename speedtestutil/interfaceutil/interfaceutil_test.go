@@ -42,3 +42,36 @@ func TestGetAvaliableLocalTCPAddr(t *testing.T) {
 
 	t.Log(tcpAddr.IP)
 }
+
+func TestCheckLocalTCPAddr(t *testing.T) {
+	pass, err := interfaceutil.CheckLocalTCPAddrByString("127.0.0.1")
+	if !pass {
+		t.Fatalf("127.0.0.1 should pass, %s\n", err)
+	}
+
+	// TEST-NET-3, 不属于本机
+	pass, err = interfaceutil.CheckLocalTCPAddrByString("203.0.113.7")
+	if pass {
+		t.Fatal("203.0.113.7 should not pass")
+	}
+	t.Log(err)
+}
+
+func TestListLocalAddrs(t *testing.T) {
+	localAddrs, err := interfaceutil.ListLocalAddrs(&interfaceutil.Filter{
+		Family: interfaceutil.FamilyIPv4,
+		Scope:  interfaceutil.ScopeHost,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(localAddrs) == 0 {
+		t.Fatal(interfaceutil.ErrNoSuchAddr)
+	}
+	for _, la := range localAddrs {
+		t.Logf("%s, mtu: %d, flags: %s, scope: %s\n", la, la.MTU, la.Flags, la.Scope)
+		if !la.IPNet.IP.IsLoopback() || la.Family() != interfaceutil.FamilyIPv4 {
+			t.Fatalf("unexpected addr: %s\n", la)
+		}
+	}
+}
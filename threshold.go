@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// violation 不满足的阈值
+	violation struct {
+		Threshold string `json:"threshold"` // 参数名, 如 min_download
+		Limit     string `json:"limit"`
+		Actual    string `json:"actual,omitempty"` // 没有测量时为空
+	}
+)
+
+var (
+	minDownload string
+	minUpload   string
+	maxLatency  string
+	maxLoss     string
+
+	// 解析后的阈值, 为 0 (maxLossRatio 为 -1) 时不检查
+	minDownloadSpeed int64
+	minUploadSpeed   int64
+	maxLatencyTime   time.Duration
+	maxLossRatio     float64 = -1
+)
+
+// speedUnits 速度的单位, 以 bps 结尾的按比特计算, 为 1000 的幂,
+// 其余的按字节计算, 为 1024 的幂, 与输出的速度一致
+var speedUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"gbps", 1e9 / 8},
+	{"mbps", 1e6 / 8},
+	{"kbps", 1e3 / 8},
+	{"bps", 1.0 / 8},
+	{"gb/s", 1 << 30},
+	{"mb/s", 1 << 20},
+	{"kb/s", 1 << 10},
+	{"b/s", 1},
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"b", 1},
+}
+
+// addSpeedThresholdFlags 下载和上传速度的阈值
+func addSpeedThresholdFlags(fs *flag.FlagSet) {
+	fs.StringVar(&minDownload, "min_download", "", "exit with code 3 if DOWNLOAD is slower, e.g. 100Mbps (10^6 bits per second) or 12MB/s (2^20 bytes per second, as printed)")
+	fs.StringVar(&minUpload, "min_upload", "", "exit with code 3 if UPLOAD is slower, e.g. 100Mbps (10^6 bits per second) or 12MB/s (2^20 bytes per second, as printed)")
+}
+
+// addLatencyThresholdFlags 延时和丢包的阈值
+func addLatencyThresholdFlags(fs *flag.FlagSet) {
+	fs.StringVar(&maxLatency, "max_latency", "", "exit with code 3 if the PING average (or HI) latency is higher, e.g. 50ms")
+	fs.StringVar(&maxLoss, "max_loss", "", "exit with code 3 if more PINGs time out, in percent, e.g. 1%")
+}
+
+// parseSpeed 解析速度, 返回字节每秒. 以 bps 结尾的按比特计算, 如 100Mbps 为 12500000,
+// 否则按字节计算, 如 12MB/s 为 12582912, 没有单位时为字节每秒
+func parseSpeed(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	unit := 1.0
+	for _, u := range speedUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, unit = strings.TrimSpace(strings.TrimSuffix(lower, u.suffix)), u.bytes
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(lower, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid speed %q", s)
+	}
+	return int64(v * unit), nil
+}
+
+// parseThresholds 解析阈值相关的参数
+func parseThresholds() (err error) {
+	if minDownload != "" {
+		minDownloadSpeed, err = parseSpeed(minDownload)
+		if err != nil {
+			return usageErrorf("parse min_download error: %s", err)
+		}
+	}
+	if minUpload != "" {
+		minUploadSpeed, err = parseSpeed(minUpload)
+		if err != nil {
+			return usageErrorf("parse min_upload error: %s", err)
+		}
+	}
+	if maxLatency != "" {
+		maxLatencyTime, err = time.ParseDuration(maxLatency)
+		if err != nil || maxLatencyTime <= 0 {
+			return usageErrorf("parse max_latency error: invalid duration %q", maxLatency)
+		}
+	}
+	if maxLoss != "" {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(maxLoss), "%"), 64)
+		if err != nil || v < 0 || v > 100 {
+			return usageErrorf("parse max_loss error: invalid percent %q", maxLoss)
+		}
+		maxLossRatio = v / 100
+	}
+	return nil
+}
+
+// hasThresholds 是否设置了阈值
+func hasThresholds() bool {
+	return minDownloadSpeed > 0 || minUploadSpeed > 0 || maxLatencyTime > 0 || maxLossRatio >= 0
+}
+
+func (v *violation) String() string {
+	actual := v.Actual
+	if actual == "" {
+		actual = "not measured"
+	}
+	return fmt.Sprintf("%s %s, actual %s", v.Threshold, v.Limit, actual)
+}
+
+// checkThresholds 检查测速结果, 返回不满足的阈值, 没有测量的项目也视为不满足
+func checkThresholds(res *testResult) (violations []*violation) {
+	checkSpeed := func(threshold string, min int64, speed func() int64, measured bool) {
+		if min <= 0 {
+			return
+		}
+		v := &violation{
+			Threshold: threshold,
+			Limit:     formatSpeed(min),
+		}
+		if measured {
+			actual := speed()
+			if actual >= min {
+				return
+			}
+			v.Actual = formatSpeed(actual)
+		}
+		violations = append(violations, v)
+	}
+	checkSpeed("min_download", minDownloadSpeed, func() int64 { return res.Download.AverageSpeed }, res.Download != nil)
+	checkSpeed("min_upload", minUploadSpeed, func() int64 { return res.Upload.AverageSpeed }, res.Upload != nil)
+
+	if maxLatencyTime > 0 {
+		v := &violation{
+			Threshold: "max_latency",
+			Limit:     maxLatencyTime.String(),
+		}
+		if latency := res.latency(); latency > 0 {
+			v.Actual = latency.String()
+			if latency <= maxLatencyTime {
+				v = nil
+			}
+		}
+		if v != nil {
+			violations = append(violations, v)
+		}
+	}
+
+	if maxLossRatio >= 0 {
+		v := &violation{
+			Threshold: "max_loss",
+			Limit:     formatLoss(maxLossRatio),
+		}
+		if res.Ping != nil && len(res.Ping.Latencies) > 0 {
+			loss := res.Ping.Loss()
+			v.Actual = formatLoss(loss)
+			if loss <= maxLossRatio {
+				v = nil
+			}
+		}
+		if v != nil {
+			violations = append(violations, v)
+		}
+	}
+	return
+}
+
+// formatLoss 以百分比显示丢包率
+func formatLoss(loss float64) string {
+	return strconv.FormatFloat(loss*100, 'f', -1, 64) + "%"
+}
+
+// checkResults 检查各个成功的测速结果, 记录并输出不满足的阈值.
+// 有测速失败的结果时返回退出码为 exitError 的错误, 否则有不满足的阈值时返回退出码为 exitThreshold 的错误
+func checkResults(results ...*testResult) error {
+	var n, failed int
+	for _, res := range results {
+		if res == nil {
+			continue
+		}
+		if res.Error != "" {
+			failed++
+			continue
+		}
+		res.Violations = checkThresholds(res)
+		prefix := ""
+		if res.Label != "" {
+			prefix = "[" + res.Label + "] "
+		}
+		for _, v := range res.Violations {
+			fmt.Fprintf(out, "%sTHRESHOLD: %s\n", prefix, v)
+		}
+		n += len(res.Violations)
+	}
+	if failed > 0 {
+		return codeError{exitError, fmt.Errorf("%d of %d tests failed", failed, len(results))}
+	}
+	if n > 0 {
+		return codeError{exitThreshold, fmt.Errorf("%d thresholds violated", n)}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"github.com/iikira/speedtest/speedtestclient"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// setThresholds 设置阈值, 测试结束后恢复
+func setThresholds(t *testing.T, download, upload int64, latency time.Duration, loss float64) {
+	oldOut := out
+	out = ioutil.Discard
+	minDownloadSpeed, minUploadSpeed, maxLatencyTime, maxLossRatio = download, upload, latency, loss
+	t.Cleanup(func() {
+		out = oldOut
+		minDownloadSpeed, minUploadSpeed, maxLatencyTime, maxLossRatio = 0, 0, 0, -1
+	})
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if ce, ok := err.(codeError); ok {
+		return ce.code
+	}
+	return exitError
+}
+
+func TestCheckResults(t *testing.T) {
+	ok := func(label string, download int64) *testResult {
+		return &testResult{
+			Label:    label,
+			Download: &speedtestclient.UpDownloadRes{AverageSpeed: download},
+		}
+	}
+	failed := &testResult{Label: "failed", Error: "HI error: connection refused"}
+
+	cases := []struct {
+		name    string
+		results []*testResult
+		code    int
+	}{
+		{"all passed", []*testResult{ok("a", 200), ok("b", 300)}, exitOK},
+		{"below threshold", []*testResult{ok("a", 200), ok("b", 50)}, exitThreshold},
+		{"one failed", []*testResult{ok("a", 200), failed}, exitError},
+		{"all failed", []*testResult{failed, failed}, exitError},
+		{"failed and below threshold", []*testResult{ok("a", 50), failed}, exitError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setThresholds(t, 100, 0, 0, -1)
+			code := exitCode(checkResults(c.results...))
+			if code != c.code {
+				t.Errorf("exit code = %d, want %d", code, c.code)
+			}
+		})
+	}
+}
+
+func TestCheckResultsNoThresholds(t *testing.T) {
+	setThresholds(t, 0, 0, 0, -1)
+	err := checkResults(&testResult{}, &testResult{Error: "DOWNLOAD error: EOF"})
+	if code := exitCode(err); code != exitError {
+		t.Errorf("exit code = %d, want %d", code, exitError)
+	}
+}
+
+func TestParseSpeed(t *testing.T) {
+	cases := []struct {
+		s     string
+		speed int64 // 为 0 表示应当出错
+	}{
+		{"100Mbps", 12500000},
+		{"100 mbps", 12500000},
+		{"1Gbps", 125000000},
+		{"800kbps", 100000},
+		{"8bps", 1},
+		{"12MB/s", 12 << 20},
+		{"12.5MB/s", 12.5 * (1 << 20)},
+		{"1GB/s", 1 << 30},
+		{"512KB/s", 512 << 10},
+		{"2MB", 2 << 20},
+		{"1000B/s", 1000},
+		{"1000", 1000},
+		{"", 0},
+		{"fast", 0},
+		{"Mbps", 0},
+		{"0Mbps", 0},
+		{"-1MB/s", 0},
+		{"100Mb/ps", 0},
+	}
+	for _, c := range cases {
+		speed, err := parseSpeed(c.s)
+		if c.speed == 0 {
+			if err == nil {
+				t.Errorf("parseSpeed(%q) = %d, want error", c.s, speed)
+			}
+			continue
+		}
+		if err != nil || speed != c.speed {
+			t.Errorf("parseSpeed(%q) = %d, %v, want %d", c.s, speed, err, c.speed)
+		}
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	speed := func(v int64) *speedtestclient.UpDownloadRes {
+		return &speedtestclient.UpDownloadRes{AverageSpeed: v}
+	}
+	ping := func(latencies ...time.Duration) *speedtestclient.PingRes {
+		return speedtestclient.NewPingRes(latencies)
+	}
+	cases := []struct {
+		name       string
+		download   int64
+		upload     int64
+		latency    time.Duration
+		loss       float64
+		res        *testResult
+		violations []string // 不满足的阈值及实际值
+	}{
+		{
+			name:     "speed passed",
+			download: 100, upload: 50, loss: -1,
+			res: &testResult{Download: speed(100), Upload: speed(60)},
+		},
+		{
+			name:     "speed below",
+			download: 100, upload: 50, loss: -1,
+			res:        &testResult{Download: speed(99), Upload: speed(60)},
+			violations: []string{"min_download 100B/s, actual 99B/s"},
+		},
+		{
+			name:     "speed not measured",
+			download: 100, upload: 50, loss: -1,
+			res:        &testResult{Download: speed(100)},
+			violations: []string{"min_upload 50B/s, actual not measured"},
+		},
+		{
+			name:    "ping latency",
+			latency: 50 * time.Millisecond, loss: -1,
+			res:        &testResult{Ping: ping(40*time.Millisecond, 80*time.Millisecond)},
+			violations: []string{"max_latency 50ms, actual 60ms"},
+		},
+		{
+			name:    "hi latency without ping",
+			latency: 50 * time.Millisecond, loss: -1,
+			res: &testResult{Hi: &speedtestclient.HIRes{Latency: 30 * time.Millisecond}},
+		},
+		{
+			name:    "latency not measured",
+			latency: 50 * time.Millisecond, loss: -1,
+			res:        &testResult{},
+			violations: []string{"max_latency 50ms, actual not measured"},
+		},
+		{
+			name: "loss passed",
+			loss: 0.5,
+			res:  &testResult{Ping: ping(10*time.Millisecond, -1)},
+		},
+		{
+			name:       "loss above",
+			loss:       0,
+			res:        &testResult{Ping: ping(10*time.Millisecond, -1)},
+			violations: []string{"max_loss 0%, actual 50%"},
+		},
+		{
+			name:       "loss not measured",
+			loss:       0.01,
+			res:        &testResult{},
+			violations: []string{"max_loss 1%, actual not measured"},
+		},
+		{
+			name:     "several",
+			download: 100, latency: 50 * time.Millisecond, loss: 0,
+			res: &testResult{Download: speed(10), Ping: ping(100*time.Millisecond, -1)},
+			violations: []string{
+				"min_download 100B/s, actual 10B/s",
+				"max_latency 50ms, actual 100ms",
+				"max_loss 0%, actual 50%",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setThresholds(t, c.download, c.upload, c.latency, c.loss)
+			violations := checkThresholds(c.res)
+			if len(violations) != len(c.violations) {
+				t.Fatalf("violations = %v, want %v", violations, c.violations)
+			}
+			for i, v := range violations {
+				if v.String() != c.violations[i] {
+					t.Errorf("violation %d = %q, want %q", i, v, c.violations[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/iikira/speedtest/speedtestclient"
+	"github.com/iikira/speedtest/speedtestutil/interfaceutil"
+	"net"
+	"strings"
+)
+
+type (
+	// uplink 一个网卡及其用于测速的本地地址
+	uplink struct {
+		iface  string
+		family string // ipv4 或 ipv6
+		addr   *net.TCPAddr
+	}
+)
+
+// listUplinks 列出网卡, 每个网卡选取一个可用的 IPv4 和 IPv6 地址,
+// names 为空则使用所有已启用的非回环网卡, 指定的网卡不存在时返回错误
+func listUplinks(names []string) (uplinks []*uplink, err error) {
+	for _, name := range names {
+		_, err = net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %s", name, err)
+		}
+	}
+	localAddrs, err := interfaceutil.ListLocalAddrs(&interfaceutil.Filter{
+		Scope:       interfaceutil.ScopeGlobal,
+		Interfaces:  names,
+		IncludeDown: len(names) > 0,
+	})
+	if err != nil {
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, la := range localAddrs {
+		if len(names) == 0 && la.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		family := la.Family().String()
+		if seen[la.Interface+"/"+family] {
+			continue
+		}
+		seen[la.Interface+"/"+family] = true
+
+		uplinks = append(uplinks, &uplink{
+			iface:  la.Interface,
+			family: family,
+			addr:   la.TCPAddr(),
+		})
+	}
+	return uplinks, nil
+}
+
+func (u *uplink) testOptions() *testOptions {
+	topt := &testOptions{
+		label:     u.iface + "/" + u.family,
+		localAddr: u.addr,
+	}
+	// 默认仅绑定地址, 在策略路由下不能保证从该网卡发出,
+	// bind_interfaces 时再以 SO_BINDTODEVICE 绑定网卡, 需要 CAP_NET_RAW
+	if isBindInterfaces {
+		opts := sockOpts
+		opts.BindDevice = u.iface
+		topt.sockOpts = &opts
+	}
+	return topt
+}
+
+// runUplinks 分别通过每个网卡对 server 测速
+func runUplinks(server *speedtestclient.SpeedtestServer, uplinks []*uplink, concurrent bool) (report *comparisonReport) {
+	topts := make([]*testOptions, 0, len(uplinks))
+	for _, u := range uplinks {
+		topts = append(topts, u.testOptions())
+	}
+	return runComparison("uplinks", server, topts, concurrent)
+}
+
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
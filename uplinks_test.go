@@ -0,0 +1,13 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListUplinksUnknownInterface(t *testing.T) {
+	_, err := listUplinks([]string{"lo", "nosuchif0"})
+	if err == nil || !strings.Contains(err.Error(), "nosuchif0") {
+		t.Fatalf("error = %v, want unknown interface nosuchif0", err)
+	}
+}